@@ -0,0 +1,177 @@
+package headers
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// String formats the rules as _headers file data, with a blank line between
+// each rule.
+func (f File) String() string {
+	blocks := []string{}
+	for _, rule := range f {
+		blocks = append(blocks, rule.String())
+	}
+	return strings.Join(blocks, "\n")
+}
+
+// String formats the rule as a _headers file block: any comments, the pattern
+// line, and then each header indented by two spaces.
+func (r Rule) String() string {
+	var b strings.Builder
+	for _, comment := range r.Comments {
+		b.WriteString(comment + "\n")
+	}
+	if len(r.Methods) > 0 {
+		b.WriteString(strings.Join(r.Methods, ",") + " ")
+	}
+	b.WriteString(formatPattern(r.Pattern) + "\n")
+	for _, header := range r.Headers {
+		b.WriteString("  " + header.String() + "\n")
+	}
+	return b.String()
+}
+
+// String formats the header as a _headers file line, without indentation:
+// "Name: Value" to set a header, or "! Name" to detach one. A value which
+// parsing with ParseOptions.Unquote would change, as it starts or ends with
+// whitespace or is itself a quoted string, is written as a quoted string, to
+// be read back as it was with Unquote.
+func (h Header) String() string {
+	value := h.Value
+	first, _ := utf8.DecodeRuneInString(value)
+	last, _ := utf8.DecodeLastRuneInString(value)
+	_, err := strconv.Unquote(value)
+	if unicode.IsSpace(first) || unicode.IsSpace(last) || (strings.HasPrefix(value, `"`) && err == nil) {
+		value = strconv.Quote(value)
+	}
+	if h.Detach {
+		if value != "" {
+			return "! " + h.Name + ": " + value
+		}
+		return "! " + h.Name
+	}
+	return h.Name + ": " + value
+}
+
+// WriteFile writes the rules to the named file as _headers file data. The data
+// is written to a temporary file in the same directory, which then replaces
+// the named file, so it is never left partially written. If the file already
+// exists its permissions are kept, otherwise it is created with perm.
+func (f File) WriteFile(name string, perm os.FileMode) error {
+	if info, err := os.Stat(name); err == nil {
+		perm = info.Mode().Perm()
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(name), "."+filepath.Base(name)+".tmp*")
+	if err != nil {
+		return err
+	}
+	// a no-op once the file is renamed
+	defer os.Remove(tmp.Name())
+
+	if _, err := io.WriteString(tmp, f.String()); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Chmod(perm); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), name)
+}
+
+// formatPattern reconstructs the pattern line a URL was parsed from.
+func formatPattern(pattern url.URL) string {
+	// EscapedPath would escape splats and backslashes, so only escape what
+	// would otherwise be misread.
+	out := escapePath(pattern.Path)
+	if pattern.Host != "" {
+		out = pattern.Scheme + "://" + pattern.Host + out
+	}
+	if pattern.RawQuery != "" || pattern.ForceQuery {
+		out += "?" + escape(pattern.RawQuery, unicode.IsSpace)
+	}
+	if pattern.Fragment != "" {
+		out += "#" + pattern.EscapedFragment()
+	}
+	return out
+}
+
+// escapePath percent-encodes the characters of a decoded pattern path which
+// url.Parse would not read back as the same path: percent signs, query and
+// fragment delimiters, whitespace and control characters.
+func escapePath(p string) string {
+	return escape(p, func(r rune) bool {
+		return r == '%' || r == '?' || r == '#' || unicode.IsSpace(r) || unicode.IsControl(r)
+	})
+}
+
+// escape percent-encodes every character of s for which shouldEscape is true.
+func escape(s string, shouldEscape func(rune) bool) string {
+	var b strings.Builder
+	for len(s) > 0 {
+		r, size := utf8.DecodeRuneInString(s)
+		if shouldEscape(r) {
+			for _, c := range []byte(s[:size]) {
+				fmt.Fprintf(&b, "%%%02X", c)
+			}
+		} else {
+			b.WriteString(s[:size])
+		}
+		s = s[size:]
+	}
+	return b.String()
+}
+
+// Fingerprint returns a SHA-256 hex digest of the rules, which is the same for
+// any two files with the same rules regardless of comments, blank lines or
+// indentation.
+func (f File) Fingerprint() string {
+	hash := sha256.New()
+	for _, rule := range f {
+		rule.Comments = nil
+		io.WriteString(hash, rule.String())
+	}
+	return hex.EncodeToString(hash.Sum(nil))
+}
+
+// Dump returns an indented, developer-oriented view of the parsed rules,
+// exposing the URL components each pattern was parsed into. It is intended for
+// troubleshooting why a pattern does or does not match, not as _headers output.
+func (f File) Dump() string {
+	var b strings.Builder
+	for i, rule := range f {
+		fmt.Fprintf(&b, "rule %d\n", i)
+		fmt.Fprintf(&b, "  scheme: %q\n", rule.Pattern.Scheme)
+		fmt.Fprintf(&b, "  host: %q\n", rule.Pattern.Host)
+		fmt.Fprintf(&b, "  path: %q\n", rule.Pattern.Path)
+		fmt.Fprintf(&b, "  headers:\n")
+		for _, header := range rule.Headers {
+			if header.Detach && header.Value != "" {
+				fmt.Fprintf(&b, "    detach %q = %q\n", header.Name, header.Value)
+			} else if header.Detach {
+				fmt.Fprintf(&b, "    detach %q\n", header.Name)
+			} else {
+				fmt.Fprintf(&b, "    set %q = %q\n", header.Name, header.Value)
+			}
+		}
+	}
+	return b.String()
+}
@@ -0,0 +1,218 @@
+package headers_test
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	headers "github.com/jmhobbs/cloudflare-headers-file"
+)
+
+func Test_File_Dump(t *testing.T) {
+	r := strings.NewReader(`/secure/page
+  X-Frame-Options: DENY
+
+https://myproject.pages.dev/*
+  ! X-Robots-Tag
+`)
+	file, err := headers.Parse(r)
+	assert.NoError(t, err)
+
+	assert.Equal(t, `rule 0
+  scheme: ""
+  host: ""
+  path: "/secure/page"
+  headers:
+    set "X-Frame-Options" = "DENY"
+rule 1
+  scheme: "https"
+  host: "myproject.pages.dev"
+  path: "/*"
+  headers:
+    detach "X-Robots-Tag"
+`, file.Dump())
+}
+
+func Test_File_Fingerprint(t *testing.T) {
+	parse := func(input string) headers.File {
+		file, err := headers.ParseWithOptions(strings.NewReader(input), headers.ParseOptions{KeepComments: true})
+		assert.NoError(t, err)
+		return *file
+	}
+
+	original := parse(`/secure/page
+  X-Frame-Options: DENY
+  ! X-Robots-Tag
+`)
+	equivalent := parse(`# a comment
+
+
+/secure/page
+	X-Frame-Options:    DENY
+	!X-Robots-Tag   
+`)
+	changed := parse(`/secure/page
+  X-Frame-Options: SAMEORIGIN
+  ! X-Robots-Tag
+`)
+
+	assert.Len(t, original.Fingerprint(), 64)
+	assert.Equal(t, original.Fingerprint(), equivalent.Fingerprint())
+	assert.NotEqual(t, original.Fingerprint(), changed.Fingerprint())
+	assert.NotEqual(t, original.Fingerprint(), headers.File{}.Fingerprint())
+}
+
+func Test_Header_String(t *testing.T) {
+	tests := []struct {
+		header   headers.Header
+		expected string
+	}{
+		{headers.Header{Name: "X-Frame-Options", Value: "DENY"}, "X-Frame-Options: DENY"},
+		{headers.Header{Name: "X-Frame-Options", Detach: true}, "! X-Frame-Options"},
+		{headers.Header{Name: "X-Robots-Tag", Value: "noindex", Detach: true}, "! X-Robots-Tag: noindex"},
+	}
+	for _, test := range tests {
+		t.Run(test.expected, func(t *testing.T) {
+			assert.Equal(t, test.expected, test.header.String())
+
+			parsed, err := headers.ParseHeaderLine(test.header.String())
+			assert.NoError(t, err)
+			assert.Equal(t, test.header, parsed)
+		})
+	}
+}
+
+func Test_Header_String_Whitespace(t *testing.T) {
+	r := strings.NewReader(`/page
+  X-Padded: "  bar  "
+  X-Leading: "\tbar"
+  X-Plain: "bar"
+  ETag: "\"abc\""
+`)
+	file, err := headers.ParseWithOptions(r, headers.ParseOptions{Unquote: true})
+	assert.NoError(t, err)
+	assert.Equal(t, []headers.Header{
+		{Name: "X-Padded", Value: "  bar  "},
+		{Name: "X-Leading", Value: "\tbar"},
+		{Name: "X-Plain", Value: "bar"},
+		{Name: "ETag", Value: `"abc"`},
+	}, (*file)[0].Headers)
+
+	assert.Equal(t, `X-Padded: "  bar  "`, (*file)[0].Headers[0].String())
+	assert.Equal(t, "X-Plain: bar", (*file)[0].Headers[2].String())
+	assert.Equal(t, `ETag: "\"abc\""`, (*file)[0].Headers[3].String())
+
+	name := filepath.Join(t.TempDir(), "_headers")
+	assert.NoError(t, file.WriteFile(name, 0644))
+
+	data, err := os.ReadFile(name)
+	assert.NoError(t, err)
+	reparsed, err := headers.ParseWithOptions(strings.NewReader(string(data)), headers.ParseOptions{Unquote: true})
+	assert.NoError(t, err)
+	assert.Equal(t, *file, *reparsed)
+}
+
+func Test_Rule_String(t *testing.T) {
+	tests := []struct {
+		name  string
+		block string
+	}{
+		{"path", "/secure/page\n  X-Frame-Options: DENY\n  ! X-Robots-Tag\n"},
+		{"splat", "/static/*\n  Access-Control-Allow-Origin: *\n"},
+		{"placeholder", "/movies/:title\n  x-movie-name: You are watching \":title\"\n"},
+		{"absolute", "https://myproject.pages.dev/*\n  X-Robots-Tag: noindex\n"},
+		{"host placeholder", "https://:subdomain.example.com/:page\n  x-subdomain: :subdomain\n"},
+		{"host wildcard", "https://api-*.example.com/v1/*\n  X-Version: :splat\n"},
+		{"escaped", "/a\\*b\n  X-Literal: true\n"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			rule, err := headers.ParseRule(test.block)
+			assert.NoError(t, err)
+			assert.Equal(t, test.block, rule.String())
+		})
+	}
+}
+
+func Test_File_String_BlankLines(t *testing.T) {
+	r := strings.NewReader(`
+
+
+/secure/page
+  X-Frame-Options: DENY
+
+
+
+# Static files
+
+/static/*
+
+  Access-Control-Allow-Origin: *
+/movies/:title
+  X-Movie-Name: :title
+
+
+
+`)
+	file, err := headers.ParseWithOptions(r, headers.ParseOptions{KeepComments: true})
+	assert.NoError(t, err)
+	assert.Len(t, *file, 3)
+
+	assert.Equal(t, `/secure/page
+  X-Frame-Options: DENY
+
+# Static files
+/static/*
+  Access-Control-Allow-Origin: *
+
+/movies/:title
+  X-Movie-Name: :title
+`, file.String())
+}
+
+func Test_File_WriteFile(t *testing.T) {
+	r := strings.NewReader(`# Security
+/secure/page
+  X-Frame-Options: DENY
+  ! X-Robots-Tag
+
+https://myproject.pages.dev/*
+  X-Robots-Tag: noindex
+`)
+	file, err := headers.ParseWithOptions(r, headers.ParseOptions{KeepComments: true})
+	assert.NoError(t, err)
+
+	dir := t.TempDir()
+	name := filepath.Join(dir, "_headers")
+
+	assert.NoError(t, file.WriteFile(name, 0644))
+
+	data, err := os.ReadFile(name)
+	assert.NoError(t, err)
+	assert.Equal(t, file.String(), string(data))
+
+	reparsed, err := headers.ParseWithOptions(strings.NewReader(string(data)), headers.ParseOptions{KeepComments: true})
+	assert.NoError(t, err)
+	assert.Equal(t, *file, *reparsed)
+
+	info, err := os.Stat(name)
+	assert.NoError(t, err)
+	assert.Equal(t, os.FileMode(0644), info.Mode().Perm())
+
+	// existing permissions are kept, and no temporary files are left behind
+	assert.NoError(t, os.Chmod(name, 0600))
+	assert.NoError(t, headers.File{}.WriteFile(name, 0644))
+
+	info, err = os.Stat(name)
+	assert.NoError(t, err)
+	assert.Equal(t, os.FileMode(0600), info.Mode().Perm())
+
+	entries, err := os.ReadDir(dir)
+	assert.NoError(t, err)
+	assert.Len(t, entries, 1)
+
+	assert.Error(t, file.WriteFile(filepath.Join(dir, "missing", "_headers"), 0644))
+}
@@ -0,0 +1,73 @@
+package headers_test
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	headers "github.com/jmhobbs/cloudflare-headers-file"
+)
+
+var fuzzSeeds = []string{
+	"/*\n  X-Robots-Tag: noindex\n",
+	"/secure/page\n  X-Frame-Options: DENY\n  ! X-Robots-Tag\n",
+	"https://:subdomain.example.com/*\n  X-Subdomain: :subdomain\n",
+	"https://*.example.com/:page\n  X-Page: :page\n  X-Splat: :splat\n",
+	"# comment\n/a\\*b\n  ! X-*\n  X-Literal: true\n",
+	"/docs/**\n\tX-Doc: :splat\n\n/my%20page\n\tX-Space: a b\n",
+	"/search?q=1#top\n  Link: </a.css>; rel=preload\n",
+}
+
+// FuzzParse checks that parsing arbitrary data never panics.
+func FuzzParse(f *testing.F) {
+	for _, seed := range fuzzSeeds {
+		f.Add(seed)
+	}
+	f.Fuzz(func(t *testing.T, data string) {
+		headers.Parse(strings.NewReader(data))
+		headers.ParseWithOptions(strings.NewReader(data), headers.ParseOptions{
+			NormalizeScheme:          true,
+			Strict:                   true,
+			AllowMethods:             true,
+			KeepComments:             true,
+			AllowPatternContinuation: true,
+		})
+	})
+}
+
+// FuzzRoundTrip checks that any file which parses is formatted by String into
+// data which parses back into the same rules.
+func FuzzRoundTrip(f *testing.F) {
+	for _, seed := range fuzzSeeds {
+		f.Add(seed)
+	}
+	f.Fuzz(func(t *testing.T, data string) {
+		file, err := headers.ParseWithOptions(strings.NewReader(data), headers.ParseOptions{KeepComments: true})
+		if err != nil {
+			return
+		}
+
+		formatted := file.String()
+		reparsed, err := headers.ParseWithOptions(strings.NewReader(formatted), headers.ParseOptions{KeepComments: true})
+		if !assert.NoError(t, err, "formatted:\n%s", formatted) {
+			return
+		}
+
+		assert.Equal(t, formatted, reparsed.String())
+		assert.Equal(t, normalized(*file), normalized(*reparsed), "formatted:\n%s", formatted)
+	})
+}
+
+// normalized drops the parts of rules which may change with formatting: line
+// numbers, and the encoding of the pattern query and fragment. The raw query
+// has no decoded form, so is only compared through the formatted data.
+func normalized(f headers.File) headers.File {
+	out := headers.File{}
+	for _, rule := range f {
+		rule.Line = 0
+		rule.Pattern.RawQuery, rule.Pattern.RawFragment = "", ""
+		out = append(out, rule)
+	}
+	return out
+}
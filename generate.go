@@ -0,0 +1,212 @@
+package headers
+
+import (
+	"fmt"
+	"go/format"
+	"go/token"
+	"strings"
+)
+
+// GenerateGo returns the source of a Go file in package pkg, implementing
+// Match(url.URL) []string for the rules of the file. The generated Match gives
+// the same headers as File.Match, with default options, but compares each
+// pattern directly rather than parsing a _headers file at runtime. The source
+// depends only on the standard library, and is intended to be written by a
+// go:generate command and compiled with the rest of a program. Its other
+// package-level identifiers are prefixed with cf, so they are unlikely to
+// collide with those of the rest of the package.
+func (f File) GenerateGo(pkg string) (string, error) {
+	if !token.IsIdentifier(pkg) {
+		return "", fmt.Errorf("invalid package name: %q", pkg)
+	}
+
+	var b strings.Builder
+	b.WriteString("// Code generated from a _headers file by GenerateGo. DO NOT EDIT.\n\n")
+	fmt.Fprintf(&b, "package %s\n\n", pkg)
+	b.WriteString(generatedPreamble)
+
+	b.WriteString("var cfRules = [][]cfHeader{\n")
+	for _, rule := range f {
+		fmt.Fprintf(&b, "\t// %s\n\t{\n", formatPattern(rule.Pattern))
+		for _, header := range rule.Headers {
+			fmt.Fprintf(&b, "\t\t{%q, %q, %t},\n", header.Name, header.Value, header.Detach)
+		}
+		b.WriteString("\t},\n")
+	}
+	b.WriteString("}\n\n")
+
+	b.WriteString("// Match returns the headers to apply to the input URL.\n")
+	b.WriteString("func Match(in url.URL) []string {\n")
+	if len(f.Hosts()) > 0 {
+		b.WriteString("\thostname := in.Hostname()\n")
+	}
+	b.WriteString("\tstack := []cfHeader{}\n")
+	for i, rule := range f {
+		generateMatch(&b, i, rule)
+	}
+	b.WriteString("\treturn cfFlatten(stack)\n}\n")
+	b.WriteString(generatedHelpers)
+
+	source, err := format.Source([]byte(b.String()))
+	if err != nil {
+		return "", fmt.Errorf("formatting generated source: %w", err)
+	}
+	return string(source), nil
+}
+
+// generateMatch writes the statement appending the headers of rule i to the
+// stack when it matches, mirroring matchPatterns.
+func generateMatch(b *strings.Builder, i int, rule Rule) {
+	path := compilePattern(rule.Pattern.Path)
+	if rule.Pattern.Host == "" {
+		generateCaptures(b, "in.Path", "/", path, "value", func(name string) {
+			generateAppend(b, i, "", name)
+		})
+		return
+	}
+
+	// as in matchPatterns, a splat in the path of a host pattern may span
+	// several segments, and a value captured from the host takes precedence
+	path.greedy = true
+	b.WriteString("	if hostname != \"\" {\n")
+	generateCaptures(b, "hostname", ".", compilePattern(rule.Pattern.Host), "hostValue", func(hostName string) {
+		generateCaptures(b, "in.Path", "/", path, "value", func(name string) {
+			if name == hostName {
+				name = ""
+			}
+			generateAppend(b, i, hostName, name)
+		})
+	})
+	b.WriteString("\t}\n")
+}
+
+// generateCaptures writes the statement comparing subject to the compiled
+// pattern, with the body written by fn for each way it may match, given the
+// name of the placeholder captured into the variable named value, if any.
+func generateCaptures(b *strings.Builder, subject, delim string, p compiledPattern, value string, fn func(name string)) {
+	b.WriteString("\tif ")
+	if p.splat != nil {
+		fmt.Fprintf(b, "%s, ok := cfCapture(%s, %q, %q, %q, %t); ok {\n", value, subject, p.splat[0], p.splat[1], delim, p.greedy)
+		fn(":splat")
+		b.WriteString("\t} else if ")
+	}
+	if p.placeholder != "" {
+		fmt.Fprintf(b, "%s, ok := cfCapture(%s, %q, %q, %q, false); ok {\n", value, subject, p.placeholderChunks[0], p.placeholderChunks[1], delim)
+		fn(p.placeholder)
+		b.WriteString("\t} else if ")
+	}
+	fmt.Fprintf(b, "%s == %q {\n", subject, p.literal)
+	fn("")
+	b.WriteString("\t}\n")
+}
+
+// generateAppend writes the statement appending the headers of rule i to the
+// stack, with the placeholders captured from the host and path substituted.
+func generateAppend(b *strings.Builder, i int, hostName, pathName string) {
+	headers := fmt.Sprintf("cfRules[%d]", i)
+	if hostName != "" {
+		headers = fmt.Sprintf("cfReplaced(%s, %q, hostValue)", headers, hostName)
+	}
+	if pathName != "" {
+		headers = fmt.Sprintf("cfReplaced(%s, %q, value)", headers, pathName)
+	}
+	fmt.Fprintf(b, "\t\tstack = append(stack, %s...)\n", headers)
+}
+
+// generatedPreamble is the imports and types of the generated source.
+const generatedPreamble = `import (
+	"net/url"
+	"path"
+	"slices"
+	"strings"
+)
+
+type cfHeader struct {
+	name   string
+	value  string
+	detach bool
+}
+
+`
+
+// generatedHelpers implement the matching, substitution and flattening of
+// values as Match does.
+const generatedHelpers = `
+// cfCapture returns the text of s between prefix and suffix, which must not
+// contain delim unless greedy.
+func cfCapture(s, prefix, suffix, delim string, greedy bool) (string, bool) {
+	if len(s) < len(prefix)+len(suffix) || !strings.HasPrefix(s, prefix) || !strings.HasSuffix(s, suffix) {
+		return "", false
+	}
+	value := s[len(prefix) : len(s)-len(suffix)]
+	if !greedy && strings.Contains(value, delim) {
+		return "", false
+	}
+	return value, true
+}
+
+var cfSanitizer = strings.NewReplacer("\r", "", "\n", "", "\x00", "")
+
+// cfReplaced substitutes the first reference to placeholder in each header value.
+func cfReplaced(headers []cfHeader, placeholder, value string) []cfHeader {
+	out := make([]cfHeader, len(headers))
+	for i, h := range headers {
+		out[i] = cfHeader{h.name, strings.Replace(h.value, placeholder, cfSanitizer.Replace(value), 1), h.detach}
+	}
+	return out
+}
+
+type cfResolved struct {
+	name   string
+	values []string
+}
+
+// cfFlatten applies the headers in order, and formats the result.
+func cfFlatten(headers []cfHeader) []string {
+	all := []cfResolved{}
+	index := func(name string) int {
+		return slices.IndexFunc(all, func(r cfResolved) bool { return strings.EqualFold(r.name, name) })
+	}
+	for _, h := range headers {
+		if h.detach {
+			if strings.Contains(h.name, "*") {
+				pattern := strings.ToLower(h.name)
+				all = slices.DeleteFunc(all, func(r cfResolved) bool {
+					matched, _ := path.Match(pattern, strings.ToLower(r.name))
+					return matched
+				})
+				continue
+			}
+			i := index(h.name)
+			if i < 0 {
+				continue
+			}
+			if h.value != "" {
+				all[i].values = slices.DeleteFunc(all[i].values, func(v string) bool { return v == h.value })
+				if len(all[i].values) > 0 {
+					continue
+				}
+			}
+			all = slices.Delete(all, i, i+1)
+			continue
+		}
+		if i := index(h.name); i >= 0 {
+			all[i].values = append(all[i].values, h.value)
+			continue
+		}
+		all = append(all, cfResolved{h.name, []string{h.value}})
+	}
+
+	out := []string{}
+	for _, r := range all {
+		if strings.EqualFold(r.name, "Set-Cookie") {
+			for _, v := range r.values {
+				out = append(out, r.name+": "+v)
+			}
+			continue
+		}
+		out = append(out, r.name+": "+strings.Join(r.values, ","))
+	}
+	return out
+}
+`
@@ -0,0 +1,127 @@
+package headers_test
+
+import (
+	"encoding/json"
+	"net/url"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	headers "github.com/jmhobbs/cloudflare-headers-file"
+)
+
+const generateMain = `package main
+
+import (
+	"encoding/json"
+	"net/url"
+	"os"
+)
+
+// common names, which the generated source must not collide with
+var rules, header, capture, replaced, resolved, flatten, sanitizer int
+
+func main() {
+	out := [][]string{}
+	for _, arg := range os.Args[1:] {
+		in, err := url.Parse(arg)
+		if err != nil {
+			panic(err)
+		}
+		out = append(out, Match(*in))
+	}
+	json.NewEncoder(os.Stdout).Encode(out)
+}
+`
+
+func Test_File_GenerateGo(t *testing.T) {
+	if testing.Short() {
+		t.Skip("builds the generated source")
+	}
+	goTool, err := exec.LookPath("go")
+	if err != nil {
+		t.Skip("go tool not found")
+	}
+
+	r := strings.NewReader(`/*
+  X-Frame-Options: DENY
+  X-Robots-Tag: noindex
+  Set-Cookie: a=1
+
+/movies/:title
+  X-Movie-Name: You are watching ":title"
+  Set-Cookie: b=2
+
+/movies/secret
+  ! X-Robots-Tag
+  ! Set-Cookie: a=1
+
+/static/**
+  X-Asset: :splat
+  ! X-*
+
+/literal\*
+  X-Literal: true
+
+https://:sub.example.com/*
+  X-Subdomain: :sub
+  x-frame-options: SAMEORIGIN
+
+https://example.org/
+  X-Org: true
+`)
+	file, err := headers.Parse(r)
+	assert.NoError(t, err)
+
+	source, err := file.GenerateGo("main")
+	assert.NoError(t, err)
+	assert.True(t, strings.HasPrefix(source, "// Code generated"))
+
+	dir := t.TempDir()
+	assert.NoError(t, os.WriteFile(filepath.Join(dir, "go.mod"), []byte("module generated\n\ngo 1.21\n"), 0o644))
+	assert.NoError(t, os.WriteFile(filepath.Join(dir, "headers.go"), []byte(source), 0o644))
+	assert.NoError(t, os.WriteFile(filepath.Join(dir, "main.go"), []byte(generateMain), 0o644))
+
+	urls := []string{
+		"https://example.com/",
+		"https://www.example.com/movies/inception",
+		"https://example.com/movies/secret",
+		"https://example.com/movies/a%0d%0aX-Injected:%20true",
+		"https://example.com/static/js/app.js",
+		"https://example.com/literal*",
+		"https://example.com/literalx",
+		"https://example.org/anything",
+		"/movies/path-only",
+	}
+	cmd := exec.Command(goTool, "run", ".")
+	cmd.Args = append(cmd.Args, urls...)
+	cmd.Dir = dir
+	output, err := cmd.Output()
+	if !assert.NoError(t, err) {
+		if exitErr, ok := err.(*exec.ExitError); ok {
+			t.Log(string(exitErr.Stderr))
+		}
+		return
+	}
+
+	var generated [][]string
+	assert.NoError(t, json.Unmarshal(output, &generated))
+	for i, raw := range urls {
+		in, err := url.Parse(raw)
+		assert.NoError(t, err)
+		assert.Equal(t, file.Match(*in), generated[i], raw)
+	}
+}
+
+func Test_File_GenerateGo_InvalidPackage(t *testing.T) {
+	_, err := headers.File{}.GenerateGo("not-a-package")
+	assert.EqualError(t, err, `invalid package name: "not-a-package"`)
+
+	source, err := headers.File{}.GenerateGo("empty")
+	assert.NoError(t, err)
+	assert.Contains(t, source, "package empty\n")
+}
@@ -2,200 +2,1293 @@ package headers
 
 import (
 	"bufio"
+	"compress/gzip"
+	"errors"
 	"fmt"
 	"io"
 	"net/url"
+	"os"
+	"path"
 	"regexp"
+	"slices"
+	"sort"
+	"strconv"
 	"strings"
+	"unicode/utf8"
 )
 
+// MaxLineLength is the maximum length of a line in a _headers file, including
+// indentation, header name and value. Cloudflare documents the limit in
+// characters, so it is counted in runes rather than bytes.
+const MaxLineLength = 2000
+
 // Header is a header to apply when a rule is matched
 type Header struct {
-	Name   string
-	Value  string
-	Detach bool
+	Name   string `json:"name"`
+	Value  string `json:"value,omitempty"`
+	Detach bool   `json:"detach,omitempty"`
 }
 
 // Rule is a pattern to match against, and the headers to apply if matched.
 type Rule struct {
+	// Pattern is the URL or path the rule applies to. A pattern with a host
+	// must match both the host and the path of a URL, where a splat in the
+	// path may span several segments. So "https://example.com/*" applies to
+	// every path on the host, but "https://example.com/about" only to the
+	// one path, and "https://example.com/" only to the root, unless matched
+	// with MatchOptions.HostWideOnTrailingSlash.
 	Pattern url.URL
 	Headers []Header
+	// Methods restricts the rule to requests using one of these HTTP methods
+	// when matched with MatchRequest. An empty list applies to all methods.
+	Methods []string
+	// Comments are the comment lines preceding the pattern in the source file,
+	// when parsed with KeepComments.
+	Comments []string
+	// Group is the name of the group the rule belongs to, set by the nearest
+	// "# @group name" comment before it when parsed with ParseGroups.
+	Group string
+	// Line is the line number of the pattern in the source file, or zero if
+	// the rule was not parsed from a file.
+	Line int
+}
+
+// HeaderAt returns the header at index, or false if it is out of range.
+func (r Rule) HeaderAt(index int) (Header, bool) {
+	if index < 0 || index >= len(r.Headers) {
+		return Header{}, false
+	}
+	return r.Headers[index], true
 }
 
 // File is a collection of Rule to match against.
 type File []Rule
 
+// RuleAt returns the rule at index, or false if it is out of range.
+func (f File) RuleAt(index int) (Rule, bool) {
+	if index < 0 || index >= len(f) {
+		return Rule{}, false
+	}
+	return f[index], true
+}
+
+// RulesSetting returns every rule which sets, rather than detaches, the named
+// header. Header names are compared case-insensitively.
+func (f File) RulesSetting(headerName string) []Rule {
+	rules := []Rule{}
+	for _, rule := range f {
+		for _, header := range rule.Headers {
+			if !header.Detach && strings.EqualFold(header.Name, headerName) {
+				rules = append(rules, rule)
+				break
+			}
+		}
+	}
+	return rules
+}
+
+// FilterByGroup returns the rules in the named group, in file order.
+func (f File) FilterByGroup(name string) File {
+	rules := File{}
+	for _, rule := range f {
+		if rule.Group == name {
+			rules = append(rules, rule)
+		}
+	}
+	return rules
+}
+
+// Hosts returns the sorted, unique host patterns of the absolute URL rules,
+// including any splats or placeholders. Path-only rules apply to every host,
+// and contribute nothing.
+func (f File) Hosts() []string {
+	hosts := []string{}
+	for _, rule := range f {
+		if rule.Pattern.Host != "" && !slices.Contains(hosts, rule.Pattern.Host) {
+			hosts = append(hosts, rule.Pattern.Host)
+		}
+	}
+	sort.Strings(hosts)
+	return hosts
+}
+
+// Placeholders returns the sorted, unique names of the placeholders used in the
+// host and path of every rule pattern, without their leading colon.
+func (f File) Placeholders() []string {
+	names := []string{}
+	for _, rule := range f {
+		for _, placeholder := range placeholderMatcher.FindAllString(rule.Pattern.Host+rule.Pattern.Path, -1) {
+			if name := placeholder[1:]; !slices.Contains(names, name) {
+				names = append(names, name)
+			}
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+// ParseOptions alter how a _headers file is parsed.
+type ParseOptions struct {
+	// NormalizeScheme accepts absolute URL patterns with an http scheme as
+	// well as https, and stores every absolute pattern with the https scheme.
+	NormalizeScheme bool
+
+	// Strict enables checks for constructs Cloudflare accepts but which are
+	// likely mistakes, such as mixing tab and space indentation in one file,
+	// a rule with no headers, or a path pattern without a leading "/".
+	Strict bool
+
+	// AllowMethods accepts an optional comma separated list of HTTP methods
+	// before the pattern, as in "GET,HEAD /api/*", restricting the rule to
+	// those methods. This is not supported by Cloudflare.
+	AllowMethods bool
+
+	// KeepComments retains comment lines, attaching each to the Comments of
+	// the rule which follows it. Comments after the last rule are dropped.
+	KeepComments bool
+
+	// ParseGroups reads "# @group name" annotation comments, setting the Group
+	// of every rule after one to its name, until the next annotation. An
+	// annotation without a name ends the group. The comments themselves are
+	// only retained with KeepComments.
+	ParseGroups bool
+
+	// AllowPatternContinuation joins a pattern line ending in a backslash with
+	// the line after it, whatever its indentation, so that long patterns may
+	// be wrapped. This is not supported by Cloudflare. As the line after it
+	// must continue the pattern, a blank line, comment or header there is an
+	// error, rather than ending the pattern.
+	AllowPatternContinuation bool
+
+	// RejectInvalidValues rejects header values containing a carriage return,
+	// line feed or NUL, which could split a response if emitted as is.
+	RejectInvalidValues bool
+
+	// MaxValueLength limits the length of each header value, in characters.
+	// The default of zero leaves values limited only by MaxLineLength.
+	MaxValueLength int
+
+	// DedupWithinRule sets how a header set more than once in a single rule
+	// is handled: DedupMerge, the default, keeps every value, to be joined
+	// when matched; DedupLastWins and DedupFirstWins keep only the last or
+	// first value; and DedupError rejects the file.
+	DedupWithinRule string
+
+	// MergeDuplicatePatterns folds the headers of a rule into the rule before
+	// it when both have the same pattern, rather than keeping two rules. As
+	// the rules are adjacent, the order headers are applied in is unchanged.
+	MergeDuplicatePatterns bool
+
+	// PrependSlash adds the leading "/" to path patterns written without one,
+	// so "secure/page" is read as "/secure/page". Otherwise such a pattern can
+	// never match, and is rejected in Strict mode.
+	PrependSlash bool
+
+	// Unquote reads a header value wrapped in double quotes as a Go quoted
+	// string, so `X-Foo: "  bar  "` sets the value "  bar  ", keeping the
+	// whitespace. A value which is not a single valid quoted string, such as
+	// `"cache", "cookies"`, is kept as written.
+	Unquote bool
+
+	// ExpandEnv replaces each ${VAR} in a header value with the value of the
+	// variable, as looked up by LookupEnv, for templated _headers files.
+	// Patterns are never expanded. An undefined variable is replaced with
+	// nothing, or rejected in Strict mode.
+	ExpandEnv bool
+
+	// LookupEnv looks up the variables for ExpandEnv, reporting whether each is
+	// defined. The default is os.LookupEnv.
+	LookupEnv func(name string) (string, bool)
+
+	// AllowEquals accepts "=" as well as ":" between a header name and value,
+	// so the mistaken "X-Foo = bar" sets X-Foo to "bar". Whichever comes first
+	// separates the name, so "X-Foo: a=b" is unaffected. Strict mode always
+	// requires ":".
+	AllowEquals bool
+}
+
+// Modes for ParseOptions.DedupWithinRule.
+const (
+	DedupMerge     = "merge"
+	DedupLastWins  = "last-wins"
+	DedupFirstWins = "first-wins"
+	DedupError     = "error"
+)
+
+// ErrLooksLikeRedirect is returned when a pattern line has the shape of a
+// _redirects rule, a source and destination optionally followed by a status,
+// such as "/old /new 301". Redirects are not supported in a _headers file.
+var ErrLooksLikeRedirect = errors.New("line looks like a redirect, which belongs in a _redirects file")
+
 // Parse the _headers file data from the input reader into rules.
 func Parse(in io.Reader) (*File, error) {
+	return ParseWithOptions(in, ParseOptions{})
+}
+
+// ParseWithOptions parses the _headers file data from the input reader into
+// rules using the provided options.
+func ParseWithOptions(in io.Reader, opts ParseOptions) (*File, error) {
 	hmap := File{}
 
+	err := parseStream(in, opts, func(rule Rule) error {
+		hmap = append(hmap, rule)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &hmap, nil
+}
+
+// ParseGzip parses gzip compressed _headers file data from the input reader
+// into rules.
+func ParseGzip(in io.Reader) (*File, error) {
+	gz, err := gzip.NewReader(in)
+	if err != nil {
+		return nil, fmt.Errorf("invalid gzip data: %w", err)
+	}
+	defer gz.Close()
+
+	return Parse(gz)
+}
+
+// ParseRule parses a block containing exactly one pattern and its headers into
+// a single rule, for validating one block at a time.
+func ParseRule(block string) (*Rule, error) {
+	var rule *Rule
+
+	err := ParseStream(strings.NewReader(block), func(r Rule) error {
+		if rule != nil {
+			return fmt.Errorf("multiple rules in block")
+		}
+		rule = &r
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	if rule == nil {
+		return nil, fmt.Errorf("no rule in block")
+	}
+
+	return rule, nil
+}
+
+// ParseStream parses the _headers file data from the input reader, calling fn
+// with each rule as soon as its block is complete. Rules are not accumulated,
+// and an error returned from fn aborts parsing and is returned.
+func ParseStream(in io.Reader, fn func(Rule) error) error {
+	return parseStream(in, ParseOptions{}, fn)
+}
+
+func parseStream(in io.Reader, opts ParseOptions, fn func(Rule) error) error {
 	var (
-		err     error
-		pattern *url.URL
-		headers []Header = []Header{}
+		rule        *Rule
+		lineNumber  int
+		indentation string
+		comments    []string
+		group       string
+		// a pattern continued onto the next line, and the line it started on
+		continued     string
+		continuedLine int
 	)
 
+	switch opts.DedupWithinRule {
+	case "", DedupMerge, DedupLastWins, DedupFirstWins, DedupError:
+	default:
+		return fmt.Errorf("unknown DedupWithinRule mode: %q", opts.DedupWithinRule)
+	}
+
 	scanner := bufio.NewScanner(in)
+	// Allow lines long enough for the line length limit to be reported,
+	// rather than bufio.ErrTooLong, even if every character is multibyte.
+	scanner.Buffer(nil, MaxLineLength*utf8.UTFMax+1)
 	for scanner.Scan() {
+		lineNumber++
 		line := scanner.Text()
+
+		if utf8.RuneCountInString(line) > MaxLineLength {
+			return fmt.Errorf("line %d exceeds %d character limit", lineNumber, MaxLineLength)
+		}
 		trimmed := strings.TrimSpace(line)
 
+		// a continued pattern must be completed by the next line
+		if continued != "" && (trimmed == "" || trimmed[0] == '#') {
+			return fmt.Errorf("pattern continued at line %d is followed by a blank line or comment", continuedLine)
+		}
+		if continued != "" && continuationHeaderMatcher.MatchString(trimmed) {
+			return fmt.Errorf("pattern continued at line %d is followed by a header at line %d", continuedLine, lineNumber)
+		}
+
 		// Ignore blank lines and comments
-		if trimmed == "" || trimmed[0] == '#' {
+		if trimmed == "" {
 			continue
 		}
+		if trimmed[0] == '#' {
+			if fields := strings.Fields(trimmed[1:]); opts.ParseGroups && len(fields) > 0 && fields[0] == "@group" {
+				group = strings.Join(fields[1:], " ")
+			}
+			if opts.KeepComments {
+				comments = append(comments, trimmed)
+			}
+			continue
+		}
+
+		patternLine := lineNumber
+		if continued != "" {
+			trimmed = continued + trimmed
+			line, patternLine = trimmed, continuedLine
+			continued = ""
+		}
 
 		// headers are indented
 		if line[0] == '\t' || line[0] == ' ' {
 			// if we don't have an open patttern, a header is invalid
-			if pattern == nil {
-				return nil, fmt.Errorf("header without pattern: %q", line)
+			if rule == nil {
+				return fmt.Errorf("header without pattern: %q", line)
 			}
 
-			// detach header
-			if trimmed[0] == '!' {
-				headers = append(headers, Header{Name: strings.TrimSpace(trimmed[1:]), Detach: true})
-			} else {
-				parts := strings.SplitN(trimmed, ":", 2)
-				if len(parts) != 2 {
-					return nil, fmt.Errorf("invalid header: %q", line)
+			if opts.Strict {
+				style := indentationStyle(line)
+				if indentation == "" {
+					indentation = style
+				}
+				if style != indentation || style == "mixed" {
+					return fmt.Errorf("inconsistent indentation at line %d", lineNumber)
+				}
+			}
+
+			header, err := parseHeaderLine(trimmed, opts.AllowEquals && !opts.Strict)
+			if err != nil {
+				return fmt.Errorf("%w at line %d", err, lineNumber)
+			}
+			if opts.Unquote && strings.HasPrefix(header.Value, `"`) {
+				if unquoted, err := strconv.Unquote(header.Value); err == nil {
+					header.Value = unquoted
+				}
+			}
+			if opts.ExpandEnv {
+				if header.Value, err = expandEnv(header.Value, opts); err != nil {
+					return fmt.Errorf("%w at line %d", err, lineNumber)
+				}
+			}
+			if opts.RejectInvalidValues && !validValue(header.Value) {
+				return fmt.Errorf("invalid character in header value at line %d", lineNumber)
+			}
+			if opts.MaxValueLength > 0 && utf8.RuneCountInString(header.Value) > opts.MaxValueLength {
+				return fmt.Errorf("value of header %q exceeds %d character limit at line %d", header.Name, opts.MaxValueLength, lineNumber)
+			}
+			if !header.Detach {
+				i := slices.IndexFunc(rule.Headers, func(existing Header) bool {
+					return !existing.Detach && strings.EqualFold(existing.Name, header.Name)
+				})
+				if i >= 0 {
+					switch opts.DedupWithinRule {
+					case DedupError:
+						return fmt.Errorf("duplicate header %q at line %d", header.Name, lineNumber)
+					case DedupFirstWins:
+						continue
+					case DedupLastWins:
+						rule.Headers = slices.Delete(rule.Headers, i, i+1)
+					}
 				}
-				headers = append(headers, Header{Name: parts[0], Value: strings.TrimSpace(parts[1])})
 			}
+			rule.Headers = append(rule.Headers, header)
 		} else {
-			if pattern != nil {
-				hmap = append(hmap, Rule{*pattern, headers})
+			if opts.AllowPatternContinuation && strings.HasSuffix(trimmed, "\\") {
+				continued, continuedLine = strings.TrimSuffix(trimmed, "\\"), patternLine
+				continue
 			}
 
-			// absolute url pattern
-			if submatches := absoluteUrlMatcher.FindStringSubmatch(trimmed); submatches != nil {
-				host := submatches[1]
-				if hostPortMatcher.MatchString(host) {
-					return nil, fmt.Errorf("invalid port in rule: %q", trimmed)
+			var methods []string
+			if opts.AllowMethods {
+				if submatches := methodMatcher.FindStringSubmatch(trimmed); submatches != nil {
+					methods = strings.Split(submatches[1], ",")
+					trimmed = strings.TrimSpace(trimmed[len(submatches[0]):])
 				}
-				pattern, err = url.Parse(strings.Replace(trimmed, host, "PLACEHOLDER", 1))
-				if err != nil {
-					return nil, err
+			}
+
+			if redirectMatcher.MatchString(trimmed) {
+				return fmt.Errorf("%w, use a _redirects parser for %q at line %d", ErrLooksLikeRedirect, trimmed, patternLine)
+			}
+
+			pattern, err := parsePattern(trimmed, opts)
+			if err != nil {
+				var urlErr *url.Error
+				if errors.As(err, &urlErr) {
+					return fmt.Errorf("line %d: %w", patternLine, err)
 				}
-				pattern.Host = host
-			} else {
-				// non-absolute url pattern (or invalid scheme)
-				pattern, err = url.Parse(trimmed)
-				if err != nil {
-					return nil, err
+				return err
+			}
+			if pattern.Host == "" && !strings.HasPrefix(pattern.Path, "/") {
+				if opts.PrependSlash {
+					pattern.Path = "/" + pattern.Path
+				} else if opts.Strict {
+					return fmt.Errorf(`path pattern must start with "/" at line %d`, patternLine)
 				}
 			}
-			if pattern.Scheme != "" && pattern.Scheme != "https" {
-				return nil, fmt.Errorf("invalid scheme: %q", pattern.Scheme)
+
+			if rule != nil {
+				if opts.MergeDuplicatePatterns && formatPattern(*pattern) == formatPattern(rule.Pattern) && slices.Equal(methods, rule.Methods) {
+					rule.Comments = append(rule.Comments, comments...)
+					comments = nil
+					continue
+				}
+				if err := emit(*rule, opts, fn); err != nil {
+					return err
+				}
 			}
-			headers = []Header{}
+			rule = &Rule{Pattern: *pattern, Headers: []Header{}, Methods: methods, Comments: comments, Group: group, Line: patternLine}
+			comments = nil
 		}
 	}
 	if err := scanner.Err(); err != nil {
-		return nil, err
+		if errors.Is(err, bufio.ErrTooLong) {
+			return fmt.Errorf("line %d exceeds %d character limit", lineNumber+1, MaxLineLength)
+		}
+		return err
 	}
 
-	if pattern != nil {
-		hmap = append(hmap, Rule{*pattern, headers})
+	if continued != "" {
+		return fmt.Errorf("pattern continued at line %d has no following line", continuedLine)
 	}
 
-	return &hmap, nil
+	if rule != nil {
+		return emit(*rule, opts, fn)
+	}
+
+	return nil
+}
+
+// emit passes a completed rule to fn, after any checks which need the whole
+// rule block.
+func emit(rule Rule, opts ParseOptions, fn func(Rule) error) error {
+	if opts.Strict && len(rule.Headers) == 0 {
+		return fmt.Errorf("rule at line %d has no headers", rule.Line)
+	}
+	return fn(rule)
+}
+
+// ParseHeaderLine parses a single header line, which may be indented, into a
+// Header. A line starting with ! is parsed as a detach.
+//
+// Leading and trailing whitespace is trimmed from the name and value, but
+// whitespace within a value, including tabs, is preserved as HTTP allows.
+func ParseHeaderLine(line string) (Header, error) {
+	return parseHeaderLine(line, false)
+}
+
+// parseHeaderLine parses a header line, accepting "=" as well as ":" between
+// the name and value if allowEquals is set. The first of the two separates
+// them, as neither may appear in a header name.
+func parseHeaderLine(line string, allowEquals bool) (Header, error) {
+	trimmed := strings.TrimSpace(line)
+	cut := func(s string) (string, string, bool) {
+		if i := strings.IndexAny(s, ":="); allowEquals && i >= 0 {
+			return s[:i], s[i+1:], true
+		}
+		return strings.Cut(s, ":")
+	}
+
+	// detach header, optionally of a single value
+	if strings.HasPrefix(trimmed, "!") {
+		name, value, _ := cut(trimmed[1:])
+		name, value = strings.TrimSpace(name), strings.TrimSpace(value)
+		if name == "" {
+			return Header{}, fmt.Errorf("detach with no header name")
+		}
+		if !headerNameMatcher.MatchString(name) {
+			return Header{}, fmt.Errorf("invalid header name: %q", name)
+		}
+		// a wildcard detach removes whole headers, so cannot take a value
+		if strings.Contains(name, "*") && value != "" {
+			return Header{}, fmt.Errorf("wildcard detach %q cannot have a value", name)
+		}
+		return Header{Name: name, Value: value, Detach: true}, nil
+	}
+
+	name, value, ok := cut(trimmed)
+	if !ok {
+		return Header{}, fmt.Errorf("invalid header: %q", line)
+	}
+	name = strings.TrimSpace(name)
+	if !headerNameMatcher.MatchString(name) {
+		return Header{}, fmt.Errorf("invalid header name: %q", name)
+	}
+	return Header{Name: name, Value: strings.TrimSpace(value)}, nil
+}
+
+// validValue reports whether value is free of the characters which may not
+// appear in a header field value.
+func validValue(value string) bool {
+	return !strings.ContainsAny(value, "\r\n\x00")
+}
+
+// expandEnv replaces each ${VAR} in value with the value of the variable.
+func expandEnv(value string, opts ParseOptions) (string, error) {
+	lookup := opts.LookupEnv
+	if lookup == nil {
+		lookup = os.LookupEnv
+	}
+	var undefined error
+	expanded := envMatcher.ReplaceAllStringFunc(value, func(reference string) string {
+		name := reference[2 : len(reference)-1]
+		v, ok := lookup(name)
+		if !ok && opts.Strict && undefined == nil {
+			undefined = fmt.Errorf("undefined variable %q", name)
+		}
+		return v
+	})
+	return expanded, undefined
+}
+
+// escapeStrayPercent escapes each percent sign in s which does not start a
+// valid escape sequence, such as in "/50%off", which url.Parse would reject.
+func escapeStrayPercent(s string) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] == '%' && (i+2 >= len(s) || !isHex(s[i+1]) || !isHex(s[i+2])) {
+			b.WriteString("%25")
+			continue
+		}
+		b.WriteByte(s[i])
+	}
+	return b.String()
+}
+
+func isHex(c byte) bool {
+	return strings.IndexByte("0123456789abcdefABCDEF", c) >= 0
+}
+
+// parsePattern parses the pattern line of a rule into a URL.
+func parsePattern(trimmed string, opts ParseOptions) (*url.URL, error) {
+	var (
+		pattern *url.URL
+		err     error
+	)
+
+	// absolute url pattern
+	if submatches := absoluteUrlMatcher.FindStringSubmatch(trimmed); submatches != nil {
+		host := submatches[2]
+		if hostPortMatcher.MatchString(host) {
+			return nil, fmt.Errorf("invalid port in rule: %q", trimmed)
+		}
+		// a splat may not capture a period, so several would each be limited
+		// to a single label; rather than guess at the intent, reject them.
+		if strings.Count(host, "*") > 1 {
+			return nil, fmt.Errorf("multiple wildcards in host not supported: %q", trimmed)
+		}
+		// The host may contain splats and placeholders which url.Parse
+		// rejects, so parse the rest of the pattern with a stand-in host.
+		rest := trimmed[len(submatches[0])-1:]
+		pattern, err = url.Parse("https://placeholder" + escapeStrayPercent(rest))
+		if err != nil {
+			return nil, fmt.Errorf("invalid pattern %q: %w", trimmed, err)
+		}
+		pattern.Scheme = strings.ToLower(submatches[1])
+		pattern.Host = host
+	} else {
+		// non-absolute url pattern (or invalid scheme)
+		pattern, err = url.Parse(escapeStrayPercent(trimmed))
+		if err != nil {
+			return nil, fmt.Errorf("invalid pattern %q: %w", trimmed, err)
+		}
+	}
+	if opts.NormalizeScheme && pattern.Scheme == "http" {
+		pattern.Scheme = "https"
+	}
+	if pattern.Scheme != "" && pattern.Scheme != "https" {
+		return nil, fmt.Errorf("invalid scheme: %q", pattern.Scheme)
+	}
+	if pattern.Scheme != "" && pattern.Host == "" {
+		return nil, fmt.Errorf("missing host in pattern: %q", trimmed)
+	}
+	if pattern.Scheme == "" && strings.HasPrefix(trimmed, "//") {
+		return nil, fmt.Errorf("missing scheme in pattern: %q", trimmed)
+	}
+	// url.Parse sets RawPath only when the path as written differs from its
+	// default encoding, which depends on the characters used. Patterns are
+	// always matched and formatted using the decoded Path, so drop RawPath
+	// rather than keep it for some patterns and not others.
+	pattern.RawPath = ""
+
+	return pattern, nil
+}
+
+// MatchOptions alter how rules are compared against a URL.
+type MatchOptions struct {
+	// CaseInsensitivePath compares paths, including splats and placeholders,
+	// without regard to case. Paths are case-sensitive by default, per HTTP.
+	CaseInsensitivePath bool
+
+	// LongestMatch applies only the headers of the matching rule with the
+	// longest literal pattern prefix, rather than combining every matching
+	// rule. Ties go to the rule appearing first in the file.
+	LongestMatch bool
+
+	// CleanPath applies path.Clean to the input path before matching, so that
+	// requests for paths like "/a/./b" or "//a/b" match a pattern for "/a/b".
+	// A trailing slash on the input path is preserved.
+	CleanPath bool
+
+	// JoinPolicy sets how the values of a header set more than once are
+	// joined. By default they are joined with a comma, except for Set-Cookie,
+	// which is repeated.
+	JoinPolicy JoinPolicy
+
+	// ASCIIHost converts internationalized domain names in both the pattern
+	// and input hosts to their ASCII Punycode form before comparing them, so
+	// a pattern written as "bücher.example" matches a request for
+	// "xn--bcher-kva.example", and the reverse. By default hosts are compared
+	// as written.
+	ASCIIHost bool
+
+	// HostWideOnTrailingSlash treats a host pattern whose path is only "/",
+	// such as "https://example.com/", as applying to every path on the host,
+	// as it is often written to mean. By default, as with Cloudflare, it
+	// applies only to the root path, and "https://example.com/*" is needed to
+	// apply to every path.
+	HostWideOnTrailingSlash bool
+
+	// SortOutput sorts the matched headers by name, compared
+	// case-insensitively, for output which does not depend on the order of
+	// the rules. By default headers are in the order their names were first
+	// set.
+	SortOutput bool
+}
+
+// flatten flattens the headers as the options set.
+func (opts MatchOptions) flatten(headers []Header) []string {
+	out := FlattenWithPolicy(headers, opts.JoinPolicy)
+	if opts.SortOutput {
+		// stable, so repeated values of a header keep their order
+		slices.SortStableFunc(out, func(a, b string) int {
+			nameA, _, _ := strings.Cut(a, ":")
+			nameB, _, _ := strings.Cut(b, ":")
+			return strings.Compare(strings.ToLower(nameA), strings.ToLower(nameB))
+		})
+	}
+	return out
 }
 
 // Match all the rules against the input URL, returning the headers to apply.
+// The input may be path-only, such as url.URL{Path: "/x"}, in which case only
+// rules without a host can match.
 func (h File) Match(in url.URL) []string {
-	headerStack := []Header{}
+	return h.MatchWithOptions(in, MatchOptions{})
+}
 
-	for _, mapping := range h {
-		hostname := in.Hostname()
+// MatchWithOptions matches all the rules against the input URL using the
+// provided options, returning the headers to apply.
+func (h File) MatchWithOptions(in url.URL, opts MatchOptions) []string {
+	return opts.flatten(h.headerStack(in, opts))
+}
 
-		// If host is set, it must match in some form
-		if mapping.Pattern.Host != "" {
-			if ok, replacement := hasSplat(mapping.Pattern.Host, hostname, "."); ok {
-				headerStack = append(headerStack, replacedHeaders(mapping.Headers, ":splat", replacement)...)
-				continue
+// MatchOK matches all the rules against the input URL, returning the headers
+// to apply and whether any rule matched at all. This distinguishes a URL no
+// rule matched from one whose matched headers were all detached.
+func (h File) MatchOK(in url.URL) ([]string, bool) {
+	matches := h.matchDetail(in, MatchOptions{})
+	return Flatten(matchedHeaders(matches, MatchOptions{})), len(matches) > 0
+}
+
+// MatchBreakdown matches all the rules against the input URL, returning the
+// headers to apply along with the names of any headers which were set by an
+// earlier rule and then removed by a detach. Detaches of a single value are
+// not reported.
+func (h File) MatchBreakdown(in url.URL) (set []string, detached []string) {
+	headerStack := h.headerStack(in, MatchOptions{})
+
+	detached = []string{}
+	present := []string{}
+	for _, header := range headerStack {
+		if !header.Detach {
+			present = append(present, header.Name)
+			continue
+		}
+		if header.Value != "" {
+			continue
+		}
+		remaining := []string{}
+		for _, name := range present {
+			if !detaches(header.Name, name) {
+				remaining = append(remaining, name)
+			} else if !slices.Contains(detached, name) {
+				detached = append(detached, name)
 			}
+		}
+		present = remaining
+	}
 
-			if ok, pattern, replacement := hasPlaceholder(mapping.Pattern.Host, hostname, "."); ok {
-				headerStack = append(headerStack, replacedHeaders(mapping.Headers, pattern, replacement)...)
-				continue
+	return Flatten(headerStack), detached
+}
+
+// RuleMatch describes a rule which matched a URL.
+type RuleMatch struct {
+	// Index is the position of the rule in the File.
+	Index int
+	Rule  Rule
+	// Bindings maps each placeholder, or ":splat", in the pattern to the value
+	// it captured from the URL.
+	Bindings map[string]string
+}
+
+// MatchDetail matches all the rules against the input URL, returning every
+// rule which matched along with the values captured by its pattern.
+func (h File) MatchDetail(in url.URL) []RuleMatch {
+	return h.matchDetail(in, MatchOptions{})
+}
+
+// Explanation describes whether a rule matched a URL, and why.
+type Explanation struct {
+	// Index is the position of the rule in the File.
+	Index   int
+	Rule    Rule
+	Matched bool
+	// Reason is the part of the pattern which decided the result, such as
+	// "path matched" or `host matched, path differed: "/admin" vs "/public"`,
+	// comparing the pattern with the URL.
+	Reason string
+}
+
+// Explain matches every rule against the input URL, returning whether each
+// matched and why, in file order. This is for debugging why a rule did, or did
+// not, apply to a URL.
+func (h File) Explain(in url.URL) []Explanation {
+	explanations := []Explanation{}
+	for i, rule := range h {
+		_, matched := rule.match(in, MatchOptions{})
+		explanations = append(explanations, Explanation{Index: i, Rule: rule, Matched: matched, Reason: rule.reason(in, matched)})
+	}
+	return explanations
+}
+
+// reason describes why the rule did, or did not, match the input URL.
+func (r Rule) reason(in url.URL, matched bool) string {
+	switch {
+	case r.Pattern.Host == "" && matched:
+		return "path matched"
+	case r.Pattern.Host == "":
+		return fmt.Sprintf("path differed: %q vs %q", r.Pattern.Path, in.Path)
+	case matched:
+		return "host and path matched"
+	case in.Hostname() == "":
+		return fmt.Sprintf("host differed: %q vs no host", r.Pattern.Host)
+	}
+	if _, ok := matchHost(compilePattern(r.Pattern.Host), in.Hostname()); ok {
+		return fmt.Sprintf("host matched, path differed: %q vs %q", r.Pattern.Path, in.Path)
+	}
+	return fmt.Sprintf("host differed: %q vs %q", r.Pattern.Host, in.Hostname())
+}
+
+// MatchWith matches all the rules against the input URL using the provided
+// function in place of the built-in pattern matching, returning the headers to
+// apply. The function reports whether the rule matches, and the values to
+// substitute in its headers keyed by placeholder, such as ":title" or ":splat".
+func (h File) MatchWith(in url.URL, match func(Rule, url.URL) (bool, map[string]string)) []string {
+	matches := []RuleMatch{}
+	for i, rule := range h {
+		if ok, bindings := match(rule, in); ok {
+			matches = append(matches, RuleMatch{Index: i, Rule: rule, Bindings: bindings})
+		}
+	}
+	return Flatten(matchedHeaders(matches, MatchOptions{}))
+}
+
+// MatchChain matches all the rules against the input URL, returning the index
+// of each rule which matched, in the order their headers are applied.
+func (h File) MatchChain(in url.URL) []int {
+	chain := []int{}
+	for i, rule := range h {
+		if _, ok := rule.match(in, MatchOptions{}); ok {
+			chain = append(chain, i)
+		}
+	}
+	return chain
+}
+
+func (h File) matchDetail(in url.URL, opts MatchOptions) []RuleMatch {
+	matches := []RuleMatch{}
+	for i, mapping := range h {
+		if bindings, ok := mapping.match(in, opts); ok {
+			if bindings == nil {
+				bindings = map[string]string{}
 			}
+			matches = append(matches, RuleMatch{Index: i, Rule: mapping, Bindings: bindings})
+		}
+	}
+	return matches
+}
 
-			if mapping.Pattern.Host == hostname {
-				headerStack = append(headerStack, mapping.Headers...)
-				continue
+// MatchResolved matches all the rules against the input URL, returning the
+// headers to apply with detaches applied and placeholders substituted. Unlike
+// Match, each value is a separate Header rather than being joined with others
+// for the same name. Headers are in the order their names were first set,
+// with the values for each name in the order they were set.
+func (h File) MatchResolved(in url.URL) []Header {
+	out := []Header{}
+	for _, resolved := range resolve(h.headerStack(in, MatchOptions{})) {
+		for _, value := range resolved.values {
+			out = append(out, Header{Name: resolved.name, Value: value})
+		}
+	}
+	return out
+}
+
+// MatchMap matches all the rules against the input URL, returning the headers
+// to apply keyed by name. When several matching rules set the same header
+// their values are joined with a comma. Unlike Flatten, this includes
+// Set-Cookie, as a map holds a single value for each name, so use Match where
+// cookies must be sent as separate headers.
+func (h File) MatchMap(in url.URL) map[string]string {
+	out := make(map[string]string)
+	for _, resolved := range resolve(h.headerStack(in, MatchOptions{})) {
+		out[resolved.name] = strings.Join(resolved.values, ",")
+	}
+	return out
+}
+
+// MatchRaw matches all the rules against the input URL, returning the headers
+// to apply in HTTP/1.1 wire format, sorted by name as with
+// MatchOptions.SortOutput, with each line terminated by CRLF.
+func (h File) MatchRaw(in url.URL) string {
+	lines := h.MatchWithOptions(in, MatchOptions{SortOutput: true})
+
+	var b strings.Builder
+	for _, line := range lines {
+		b.WriteString(line + "\r\n")
+	}
+	return b.String()
+}
+
+// MatchBatch matches all the rules against each of the input URLs, returning
+// the headers to apply keyed by the string form of each URL.
+func (h File) MatchBatch(urls []url.URL) map[string][]string {
+	out := make(map[string][]string, len(urls))
+	for _, in := range urls {
+		out[in.String()] = h.Match(in)
+	}
+	return out
+}
+
+// headerStack collects the headers of every rule matching the input URL, in
+// file order, with placeholders and splats substituted.
+func (h File) headerStack(in url.URL, opts MatchOptions) []Header {
+	return matchedHeaders(h.matchDetail(in, opts), opts)
+}
+
+// matchedHeaders collects the headers to apply from the matched rules, with
+// placeholders and splats substituted.
+func matchedHeaders(matches []RuleMatch, opts MatchOptions) []Header {
+	headerStack := []Header{}
+
+	if opts.LongestMatch {
+		longest := -1
+		for _, match := range matches {
+			if match.Rule.literalLength() > longest {
+				longest = match.Rule.literalLength()
+				headerStack = replacedHeaders(match.Rule.Headers, match.Bindings)
 			}
-			continue
 		}
+		return headerStack
+	}
 
-		// If the pattern path contains a splat, then see if it matches
-		if ok, replacement := hasSplat(mapping.Pattern.Path, in.Path, "/"); ok {
-			headerStack = append(headerStack, replacedHeaders(mapping.Headers, ":splat", replacement)...)
-			continue
+	for _, match := range matches {
+		headerStack = append(headerStack, replacedHeaders(match.Rule.Headers, match.Bindings)...)
+	}
+
+	return headerStack
+}
+
+// literalLength is the length of the pattern's host and path up to their
+// first splat or placeholder.
+func (r Rule) literalLength() int {
+	return len(literalPrefix(r.Pattern.Host)) + len(literalPrefix(r.Pattern.Path))
+}
+
+// Specificity scores how specific the rule pattern is, for ordering rules by
+// precedence or explaining it. Each literal character of the host and path
+// scores one, each placeholder costs 5, each splat 10, or 20 for a greedy
+// splat, and a host constraint adds 100. A higher score is more specific.
+func (r Rule) Specificity() int {
+	score := 0
+	if r.Pattern.Host != "" {
+		score += 100
+	}
+	for _, part := range []string{r.Pattern.Host, r.Pattern.Path} {
+		score -= 5 * len(placeholderMatcher.FindAllString(part, -1))
+		chunks, greedy := splitSplat(placeholderMatcher.ReplaceAllString(part, ""))
+		score -= 10 * (len(chunks) - 1)
+		if greedy {
+			score -= 10
+		}
+		for _, chunk := range chunks {
+			score += utf8.RuneCountInString(chunk)
 		}
+	}
+	return score
+}
 
-		// If the pattern contains a :placeholder, then see if it matches
-		if ok, placeholder, replacement := hasPlaceholder(mapping.Pattern.Path, in.Path, "/"); ok {
-			headerStack = append(headerStack, replacedHeaders(mapping.Headers, placeholder, replacement)...)
-			continue
+func literalPrefix(src string) string {
+	chunks, _ := splitSplat(src)
+	prefix := chunks[0]
+	if loc := placeholderMatcher.FindStringIndex(prefix); loc != nil {
+		prefix = prefix[:loc[0]]
+	}
+	return prefix
+}
+
+// Affects reports whether any rule matches the input URL, without computing
+// the headers to apply.
+func (h File) Affects(in url.URL) bool {
+	for _, mapping := range h {
+		if _, ok := mapping.match(in, MatchOptions{}); ok {
+			return true
 		}
+	}
+	return false
+}
 
-		if mapping.Pattern.Path == in.Path {
-			headerStack = append(headerStack, mapping.Headers...)
-			continue
+// RuleMatches reports whether the rule at index matches the input URL, along
+// with the values captured by its pattern, keyed by placeholder or ":splat".
+// An index out of range never matches.
+func (h File) RuleMatches(index int, in url.URL) (bool, map[string]string) {
+	rule, ok := h.RuleAt(index)
+	if !ok {
+		return false, nil
+	}
+	bindings, ok := rule.match(in, MatchOptions{})
+	if !ok {
+		return false, nil
+	}
+	if bindings == nil {
+		bindings = map[string]string{}
+	}
+	return true, bindings
+}
+
+// match reports whether the rule matches the input URL, along with the
+// placeholder or splat values captured while matching.
+func (r Rule) match(in url.URL, opts MatchOptions) (map[string]string, bool) {
+	hostPattern := r.Pattern.Host
+	if opts.ASCIIHost {
+		hostPattern = toASCIIHost(hostPattern)
+	}
+	return matchPatterns(r.Pattern.Host != "", compilePattern(hostPattern), compilePattern(r.Pattern.Path), in, opts)
+}
+
+// compiledRule is a rule with its host and path patterns compiled, so they
+// can be matched against many URLs without being split again each time.
+type compiledRule struct {
+	rule       Rule
+	host, path compiledPattern
+	// asciiHost is host converted to Punycode, for MatchOptions.ASCIIHost
+	asciiHost compiledPattern
+}
+
+func (r Rule) compile() compiledRule {
+	return compiledRule{
+		rule:      r,
+		host:      compilePattern(r.Pattern.Host),
+		path:      compilePattern(r.Pattern.Path),
+		asciiHost: compilePattern(toASCIIHost(r.Pattern.Host)),
+	}
+}
+
+// match reports whether the rule matches the input URL, along with the
+// placeholder or splat values captured while matching.
+func (r compiledRule) match(in url.URL, opts MatchOptions) (map[string]string, bool) {
+	hostPattern := r.host
+	if opts.ASCIIHost {
+		hostPattern = r.asciiHost
+	}
+	return matchPatterns(r.rule.Pattern.Host != "", hostPattern, r.path, in, opts)
+}
+
+// matchPatterns reports whether the compiled host and path patterns of a rule
+// match the input URL, along with the placeholder or splat values captured
+// while matching. hasHost reports whether the rule has a host pattern, which
+// is already converted to Punycode if the options set ASCIIHost.
+func matchPatterns(hasHost bool, hostPattern, pathPattern compiledPattern, in url.URL, opts MatchOptions) (map[string]string, bool) {
+	hostname := in.Hostname()
+
+	if opts.CleanPath {
+		in.Path = cleanPath(in.Path)
+	}
+
+	// If host is set, it must match in some form, as well as the path
+	var hostBindings map[string]string
+	if hasHost {
+		// even a pattern host which is only a splat needs a host to match
+		if hostname == "" {
+			return nil, false
+		}
+
+		if opts.ASCIIHost {
+			hostname = toASCIIHost(hostname)
+		}
+
+		var ok bool
+		if hostBindings, ok = matchHost(hostPattern, hostname); !ok {
+			return nil, false
+		}
+
+		if opts.HostWideOnTrailingSlash && pathPattern.literal == "/" {
+			return hostBindings, true
 		}
+		// as documented by Cloudflare for patterns like
+		// "https://example.com/*", a splat in the path of a host pattern
+		// applies to every path below it
+		pathPattern.greedy = true
 	}
 
-	return Flatten(headerStack)
+	bindings, ok := matchPath(pathPattern, in.Path, opts)
+	if !ok {
+		return nil, false
+	}
+	if hostBindings == nil {
+		return bindings, true
+	}
+	// a value captured from the host takes precedence over one of the same
+	// name captured from the path
+	for name, value := range bindings {
+		if _, ok := hostBindings[name]; !ok {
+			hostBindings[name] = value
+		}
+	}
+	return hostBindings, true
+}
+
+// matchHost reports whether the compiled host pattern matches the input
+// hostname, along with the placeholder or splat value captured while matching.
+func matchHost(hostPattern compiledPattern, hostname string) (map[string]string, bool) {
+	if ok, replacement := hasSplat(hostPattern, hostname, ".", false); ok {
+		return map[string]string{":splat": replacement}, true
+	}
+
+	if ok, replacement := hasPlaceholder(hostPattern, hostname, ".", false); ok {
+		return map[string]string{hostPattern.placeholder: replacement}, true
+	}
+
+	return nil, hostPattern.literal == hostname
+}
+
+// matchPath reports whether the compiled path pattern matches the input path,
+// along with the placeholder or splat value captured while matching.
+func matchPath(pathPattern compiledPattern, path string, opts MatchOptions) (map[string]string, bool) {
+	// If the pattern path contains a splat, then see if it matches
+	if ok, replacement := hasSplat(pathPattern, path, "/", opts.CaseInsensitivePath); ok {
+		return map[string]string{":splat": replacement}, true
+	}
+
+	// If the pattern contains a :placeholder, then see if it matches
+	if ok, replacement := hasPlaceholder(pathPattern, path, "/", opts.CaseInsensitivePath); ok {
+		return map[string]string{pathPattern.placeholder: replacement}, true
+	}
+
+	return nil, equalPath(pathPattern, path, opts.CaseInsensitivePath)
 }
 
 // Flatten headers into header strings.
+//
+// Headers are applied in order, so a detach only removes headers which came
+// before it. A detach name may contain "*" to remove every header with a
+// matching name, so "! X-*" removes all headers starting with "X-" and "! *"
+// removes every header set up to that point. A detach with a value, which
+// parsing rejects for a wildcard detach, removes only the values of the named
+// header equal to it, rather than the whole header. Header names are case-insensitive, for detaches as well as sets.
+// Values for the same header are joined with a comma, except for Set-Cookie
+// which is never joined, so each value is output separately. Headers are in
+// the order their names were first set.
 func Flatten(headers []Header) []string {
-	headersOut := make(map[string][]string)
-	for _, header := range headers {
-		if header.Detach {
-			delete(headersOut, header.Name)
-			continue
-		}
-		if _, ok := headersOut[header.Name]; !ok {
-			headersOut[header.Name] = []string{}
+	return FlattenWithPolicy(headers, nil)
+}
+
+// JoinPolicy maps header names, compared case-insensitively, to the separator
+// their values are joined with when a header is set more than once. Headers
+// mapped to JoinRepeat are never joined, and each value is output separately.
+// Headers which are not in the policy are joined with a comma, except for
+// Set-Cookie, which is repeated.
+type JoinPolicy map[string]string
+
+// JoinRepeat is the JoinPolicy separator for headers which are repeated rather
+// than joined.
+const JoinRepeat = "\x00"
+
+// separator returns the separator values of the named header are joined with.
+func (p JoinPolicy) separator(name string) string {
+	if sep, ok := p[name]; ok {
+		return sep
+	}
+	for policyName, sep := range p {
+		if strings.EqualFold(policyName, name) {
+			return sep
 		}
-		headersOut[header.Name] = append(headersOut[header.Name], header.Value)
 	}
+	// a comma may appear within a cookie, so they can never be joined
+	if strings.EqualFold(name, "Set-Cookie") {
+		return JoinRepeat
+	}
+	return ","
+}
 
+// FlattenWithPolicy flattens headers into header strings as Flatten does, with
+// the values of each header joined as the policy sets.
+func FlattenWithPolicy(headers []Header, policy JoinPolicy) []string {
 	out := []string{}
-	for name, values := range headersOut {
-		out = append(out, fmt.Sprintf("%s: %s", name, strings.Join(values, ",")))
+	for _, resolved := range resolve(headers) {
+		sep := policy.separator(resolved.name)
+		if sep == JoinRepeat {
+			for _, value := range resolved.values {
+				out = append(out, fmt.Sprintf("%s: %s", resolved.name, value))
+			}
+			continue
+		}
+		out = append(out, fmt.Sprintf("%s: %s", resolved.name, strings.Join(resolved.values, sep)))
 	}
 
 	return out
 }
 
+// detaches reports whether a detach of pattern removes the named header. The
+// pattern may use "*" to match any run of characters, as in "X-*". Header
+// names are case-insensitive.
+func detaches(pattern, name string) bool {
+	pattern, name = strings.ToLower(pattern), strings.ToLower(name)
+	if !strings.Contains(pattern, "*") {
+		return pattern == name
+	}
+	matched, _ := path.Match(pattern, name)
+	return matched
+}
+
+// resolvedHeader is a header name with the values remaining for it once
+// detaches have been applied.
+type resolvedHeader struct {
+	name   string
+	values []string
+}
+
+// resolve applies the headers in order, returning the values remaining for
+// each header name, in the order the names were set. Header names are
+// case-insensitive, so values set using different cases of a name are kept
+// together, under the first case used.
+func resolve(headers []Header) []resolvedHeader {
+	out := []resolvedHeader{}
+	index := func(name string) int {
+		return slices.IndexFunc(out, func(resolved resolvedHeader) bool {
+			return strings.EqualFold(resolved.name, name)
+		})
+	}
+	for _, header := range headers {
+		if header.Detach {
+			if strings.Contains(header.Name, "*") {
+				out = slices.DeleteFunc(out, func(resolved resolvedHeader) bool {
+					return detaches(header.Name, resolved.name)
+				})
+				continue
+			}
+			i := index(header.Name)
+			if i < 0 {
+				continue
+			}
+			if header.Value != "" {
+				out[i].values = slices.DeleteFunc(out[i].values, func(value string) bool {
+					return value == header.Value
+				})
+				if len(out[i].values) > 0 {
+					continue
+				}
+			}
+			out = slices.Delete(out, i, i+1)
+			continue
+		}
+		if i := index(header.Name); i >= 0 {
+			out[i].values = append(out[i].values, header.Value)
+			continue
+		}
+		out = append(out, resolvedHeader{name: header.Name, values: []string{header.Value}})
+	}
+	return out
+}
+
 var (
-	placeholderMatcher *regexp.Regexp = regexp.MustCompile(":[A-Za-z][[:word:]]*")
-	absoluteUrlMatcher *regexp.Regexp = regexp.MustCompile("^https?://(.*?)/")
-	hostPortMatcher    *regexp.Regexp = regexp.MustCompile(":[0-9]+$")
+	placeholderMatcher     *regexp.Regexp = regexp.MustCompile(":[A-Za-z][[:word:]]*")
+	placeholderNameMatcher *regexp.Regexp = regexp.MustCompile("^[A-Za-z][[:word:]]*$")
+	absoluteUrlMatcher     *regexp.Regexp = regexp.MustCompile("(?i)^(https?)://(.*?)/")
+	hostPortMatcher        *regexp.Regexp = regexp.MustCompile(":[0-9]+$")
+	headerNameMatcher      *regexp.Regexp = regexp.MustCompile("^[!#$%&'*+.^_`|~0-9A-Za-z-]+$")
+	methodMatcher          *regexp.Regexp = regexp.MustCompile(`^([A-Z]+(?:,[A-Z]+)*)\s+`)
+	envMatcher             *regexp.Regexp = regexp.MustCompile(`\$\{[A-Za-z_][A-Za-z0-9_]*\}`)
+	// continuationHeaderMatcher matches a line which is a header or detach,
+	// rather than the continuation of a pattern
+	continuationHeaderMatcher *regexp.Regexp = regexp.MustCompile("^(!|[!#$%&'*+.^_`|~0-9A-Za-z-]+\\s*:)")
+	// a _redirects rule, with a source, destination, and optional status
+	redirectMatcher *regexp.Regexp = regexp.MustCompile(`^(?i)(/|https?://)\S*\s+(/|https?://)\S*(\s+[0-9]{3}!?)?$`)
 )
 
-func hasPlaceholder(src, in, disallowed string) (bool, string, string) {
+// indentationStyle describes the leading whitespace of line as "tabs",
+// "spaces", or "mixed".
+func indentationStyle(line string) string {
+	indent := line[:len(line)-len(strings.TrimLeft(line, " \t"))]
+	switch {
+	case strings.Trim(indent, "\t") == "":
+		return "tabs"
+	case strings.Trim(indent, " ") == "":
+		return "spaces"
+	}
+	return "mixed"
+}
+
+// compiledPattern is a host or path pattern split around its first splat and
+// its first placeholder, the only ones which capture a value when matching.
+type compiledPattern struct {
+	// splat is the text before and after the first splat, if there is one
+	splat  []string
+	greedy bool
+	// placeholder is the first placeholder, if there is one, and
+	// placeholderChunks the text before and after it
+	placeholder       string
+	placeholderChunks []string
+	// literal is the pattern with escaped splats unescaped
+	literal string
+}
+
+func compilePattern(src string) compiledPattern {
+	// most patterns are static, and need no splitting
+	if !strings.ContainsAny(src, `*\:`) {
+		return compiledPattern{literal: src}
+	}
+	p := compiledPattern{literal: unescapeSplat(src)}
+	if chunks, greedy := splitSplat(src); len(chunks) > 1 {
+		p.splat, p.greedy = chunks[:2], greedy
+	}
 	if placeholder := placeholderMatcher.FindString(src); placeholder != "" {
 		chunks := strings.SplitN(src, placeholder, 2)
-		if strings.HasPrefix(in, chunks[0]) && strings.HasSuffix(in, chunks[1]) {
-			replacement := strings.TrimPrefix(strings.TrimSuffix(in, chunks[1]), chunks[0])
+		p.placeholder = placeholder
+		p.placeholderChunks = []string{unescapeSplat(chunks[0]), unescapeSplat(chunks[1])}
+	}
+	return p
+}
+
+func hasPlaceholder(p compiledPattern, in, disallowed string, fold bool) (bool, string) {
+	if chunks := p.placeholderChunks; chunks != nil {
+		if len(in) >= len(chunks[0])+len(chunks[1]) && hasPrefix(in, chunks[0], fold) && hasSuffix(in, chunks[1], fold) {
+			replacement := in[len(chunks[0]) : len(in)-len(chunks[1])]
 			if !strings.Contains(replacement, disallowed) {
-				return true, placeholder, replacement
+				return true, replacement
 			}
 		}
 	}
-	return false, "", ""
+	return false, ""
 }
 
-func hasSplat(src, in, disallowed string) (bool, string) {
-	if strings.Contains(src, "*") {
-		chunks := strings.Split(src, "*")
-		if strings.HasPrefix(in, chunks[0]) && strings.HasSuffix(in, chunks[1]) {
-			replacing := strings.TrimPrefix(strings.TrimSuffix(in, chunks[1]), chunks[0])
-			if !strings.Contains(replacing, disallowed) {
+func hasSplat(p compiledPattern, in, disallowed string, fold bool) (bool, string) {
+	if chunks := p.splat; chunks != nil {
+		if len(in) >= len(chunks[0])+len(chunks[1]) && hasPrefix(in, chunks[0], fold) && hasSuffix(in, chunks[1], fold) {
+			replacing := in[len(chunks[0]) : len(in)-len(chunks[1])]
+			if p.greedy || !strings.Contains(replacing, disallowed) {
 				return true, replacing
 			}
 		}
@@ -203,14 +1296,107 @@ func hasSplat(src, in, disallowed string) (bool, string) {
 	return false, ""
 }
 
-func replacedHeaders(headers []Header, placeholder, replacement string) []Header {
+// splitSplat splits src around each splat, treating an escaped \* as a
+// literal asterisk rather than a splat. A double ** is a single greedy splat,
+// which may capture the delimiter, and greedy reports whether the first splat
+// is one.
+func splitSplat(src string) (chunks []string, greedy bool) {
+	if !strings.ContainsAny(src, `*\`) {
+		return []string{src}, false
+	}
+	var chunk strings.Builder
+	for i := 0; i < len(src); i++ {
+		switch {
+		case src[i] == '\\' && i+1 < len(src) && src[i+1] == '*':
+			chunk.WriteByte('*')
+			i++
+		case src[i] == '*':
+			if i+1 < len(src) && src[i+1] == '*' {
+				greedy = greedy || len(chunks) == 0
+				i++
+			}
+			chunks = append(chunks, chunk.String())
+			chunk.Reset()
+		default:
+			chunk.WriteByte(src[i])
+		}
+	}
+	return append(chunks, chunk.String()), greedy
+}
+
+func unescapeSplat(src string) string {
+	return strings.ReplaceAll(src, `\*`, "*")
+}
+
+// cleanPath is path.Clean, preserving a trailing slash and an empty path.
+func cleanPath(p string) string {
+	if p == "" {
+		return p
+	}
+	cleaned := path.Clean(p)
+	if strings.HasSuffix(p, "/") && cleaned != "/" {
+		cleaned += "/"
+	}
+	return cleaned
+}
+
+func equalPath(p compiledPattern, in string, fold bool) bool {
+	if fold {
+		return strings.EqualFold(p.literal, in)
+	}
+	return p.literal == in
+}
+
+func hasPrefix(s, prefix string, fold bool) bool {
+	if fold {
+		return len(s) >= len(prefix) && strings.EqualFold(s[:len(prefix)], prefix)
+	}
+	return strings.HasPrefix(s, prefix)
+}
+
+func hasSuffix(s, suffix string, fold bool) bool {
+	if fold {
+		return len(s) >= len(suffix) && strings.EqualFold(s[len(s)-len(suffix):], suffix)
+	}
+	return strings.HasSuffix(s, suffix)
+}
+
+func replacedHeaders(headers []Header, bindings map[string]string) []Header {
+	if len(bindings) == 0 {
+		return headers
+	}
 	out := []Header{}
 	for _, header := range headers {
 		out = append(out, Header{
 			Name:   header.Name,
-			Value:  strings.Replace(header.Value, placeholder, replacement, 1),
+			Value:  replacedValue(header.Value, bindings),
 			Detach: header.Detach,
 		})
 	}
 	return out
 }
+
+// captureSanitizer strips the characters which could split a response from a
+// value captured from the request URL, where a percent-encoded CR, LF or NUL
+// would otherwise be substituted into a header value as is.
+var captureSanitizer = strings.NewReplacer("\r", "", "\n", "", "\x00", "")
+
+// replacedValue substitutes the first reference to each binding in value,
+// stripping any CR, LF or NUL from the captured values. Longer placeholders
+// are substituted first, so ":ab" is never taken as a reference to ":a".
+func replacedValue(value string, bindings map[string]string) string {
+	placeholders := make([]string, 0, len(bindings))
+	for placeholder := range bindings {
+		placeholders = append(placeholders, placeholder)
+	}
+	slices.SortFunc(placeholders, func(a, b string) int {
+		if len(a) != len(b) {
+			return len(b) - len(a)
+		}
+		return strings.Compare(a, b)
+	})
+	for _, placeholder := range placeholders {
+		value = strings.Replace(value, placeholder, captureSanitizer.Replace(bindings[placeholder]), 1)
+	}
+	return value
+}
@@ -1,7 +1,12 @@
 package headers_test
 
 import (
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"fmt"
 	"net/url"
+	"slices"
 	"strings"
 	"testing"
 
@@ -33,26 +38,65 @@ https://myproject.pages.dev/*
 	assert.NoError(t, err)
 
 	assert.Equal(t, headers.File{
-		headers.Rule{url.URL{Path: "/secure/page"}, []headers.Header{
+		headers.Rule{Pattern: url.URL{Path: "/secure/page"}, Headers: []headers.Header{
 			{Name: "X-Frame-Options", Value: "DENY"},
 			{Name: "X-Content-Type-Options", Value: "nosniff"},
 			{Name: "Referrer-Policy", Value: "no-referrer"},
-		}},
+		}, Line: 2},
 		headers.Rule{
-			url.URL{Path: "/static/*", RawPath: "/static/*"}, []headers.Header{
+			Pattern: url.URL{Path: "/static/*"}, Headers: []headers.Header{
 				{Name: "Access-Control-Allow-Origin", Value: "*"},
 				{Name: "X-Robots-Tag", Value: "nosnippet"},
-			}},
-		headers.Rule{url.URL{Scheme: "https", Host: "myproject.pages.dev", Path: "/*", RawPath: "/*"}, []headers.Header{
+			}, Line: 7},
+		headers.Rule{Pattern: url.URL{Scheme: "https", Host: "myproject.pages.dev", Path: "/*"}, Headers: []headers.Header{
 			{Name: "X-Robots-Tag", Value: "noindex"},
-		}},
+		}, Line: 11},
 	}, *file)
 }
 
 /*
 A project is limited to 100 header rules. Each line in the _headers file has a 2,000 character limit. The entire line, including spacing, header name, and value, counts towards this limit.
 */
-// TODO: Not implemented
+// TODO: Rule limit not implemented
+func Test_Parse_LineLimit(t *testing.T) {
+	tests := []struct {
+		name     string
+		length   int
+		errorMsg string
+	}{
+		{"under limit", 1900, ""},
+		{"at limit", 2000, ""},
+		{"over limit", 2100, "line 2 exceeds 2000 character limit"},
+		{"over scanner buffer", 100000, "line 2 exceeds 2000 character limit"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			line := "  Content-Security-Policy: "
+			line += strings.Repeat("a", test.length-len(line))
+
+			_, err := headers.Parse(strings.NewReader("/*\n" + line + "\n"))
+			if test.errorMsg != "" {
+				assert.EqualError(t, err, test.errorMsg)
+			} else {
+				assert.NoError(t, err)
+			}
+		})
+	}
+}
+
+// The limit is in characters, not bytes.
+func Test_Parse_LineLimitMultibyte(t *testing.T) {
+	line := "  X-Emoji: "
+	under := line + strings.Repeat("é", 1900-len(line))
+	assert.Greater(t, len(under), 2000)
+
+	_, err := headers.Parse(strings.NewReader("/*\n" + under + "\n"))
+	assert.NoError(t, err)
+
+	over := line + strings.Repeat("😀", 2001-len(line))
+	_, err = headers.Parse(strings.NewReader("/*\n" + over + "\n"))
+	assert.EqualError(t, err, "line 2 exceeds 2000 character limit")
+}
 
 /*
 Using absolute URLs is supported, though be aware that absolute URLs must begin with https and specifying a port is not supported.
@@ -78,6 +122,26 @@ func Test_Parse_Absolute(t *testing.T) {
 			"https://myproject.pages.dev:1234/*",
 			true,
 		},
+		{
+			"uppercase scheme",
+			"HTTPS://myproject.pages.dev/*",
+			false,
+		},
+		{
+			"uppercase invalid scheme",
+			"HTTP://myproject.pages.dev/*",
+			true,
+		},
+		{
+			"single host wildcard",
+			"https://*.example.com/*",
+			false,
+		},
+		{
+			"multiple host wildcards",
+			"https://*.*.example.com/*",
+			true,
+		},
 	}
 	for _, test := range tests {
 		t.Run(test.name, func(t *testing.T) {
@@ -154,12 +218,12 @@ func Test_Parse_Detach(t *testing.T) {
 	assert.NoError(t, err)
 
 	assert.EqualValues(t, headers.File{
-		headers.Rule{url.URL{Path: "/*", RawPath: "/*"}, []headers.Header{
+		headers.Rule{Pattern: url.URL{Path: "/*"}, Headers: []headers.Header{
 			{Name: "Content-Security-Policy", Value: "default-src 'self';"},
-		}},
-		headers.Rule{url.URL{Path: "/*.jpg", RawPath: "/*.jpg"}, []headers.Header{
+		}, Line: 1},
+		headers.Rule{Pattern: url.URL{Path: "/*.jpg"}, Headers: []headers.Header{
 			{Name: "Content-Security-Policy", Detach: true},
-		}},
+		}, Line: 4},
 	}, *file)
 }
 
@@ -345,3 +409,2319 @@ https://subdomain.:1domain.com/*
 	out := file.Match(*input)
 	assert.ElementsMatch(t, []string{}, out)
 }
+
+func Test_File_Match_CaseInsensitivePath(t *testing.T) {
+	r := strings.NewReader(`/Secure/Page
+  X-Frame-Options: DENY
+
+/Static/*
+  X-Static: :splat
+
+/Movies/:title
+  X-Movie: :title
+`)
+	file, err := headers.Parse(r)
+	assert.NoError(t, err)
+
+	tests := []struct {
+		name        string
+		inputURL    string
+		insensitive []string
+		sensitive   []string
+	}{
+		{
+			name:        "exact",
+			inputURL:    "https://example.com/secure/page",
+			insensitive: []string{"X-Frame-Options: DENY"},
+			sensitive:   []string{},
+		},
+		{
+			name:        "splat",
+			inputURL:    "https://example.com/static/Image.jpg",
+			insensitive: []string{"X-Static: Image.jpg"},
+			sensitive:   []string{},
+		},
+		{
+			name:        "placeholder",
+			inputURL:    "https://example.com/MOVIES/Star-Wars",
+			insensitive: []string{"X-Movie: Star-Wars"},
+			sensitive:   []string{},
+		},
+		{
+			name:        "same case",
+			inputURL:    "https://example.com/Secure/Page",
+			insensitive: []string{"X-Frame-Options: DENY"},
+			sensitive:   []string{"X-Frame-Options: DENY"},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			input, err := url.Parse(test.inputURL)
+			assert.NoError(t, err)
+
+			assert.ElementsMatch(t, test.sensitive, file.Match(*input))
+			assert.ElementsMatch(t, test.insensitive, file.MatchWithOptions(*input, headers.MatchOptions{CaseInsensitivePath: true}))
+		})
+	}
+}
+
+func Test_File_RuleAt(t *testing.T) {
+	r := strings.NewReader(`/secure/page
+  X-Frame-Options: DENY
+  ! X-Robots-Tag
+`)
+	file, err := headers.Parse(r)
+	assert.NoError(t, err)
+
+	rule, ok := file.RuleAt(0)
+	assert.True(t, ok)
+	assert.Equal(t, "/secure/page", rule.Pattern.Path)
+
+	header, ok := rule.HeaderAt(1)
+	assert.True(t, ok)
+	assert.Equal(t, headers.Header{Name: "X-Robots-Tag", Detach: true}, header)
+
+	for _, index := range []int{-1, 1} {
+		_, ok = file.RuleAt(index)
+		assert.False(t, ok)
+	}
+
+	for _, index := range []int{-1, 2} {
+		_, ok = rule.HeaderAt(index)
+		assert.False(t, ok)
+	}
+}
+
+func Test_File_RuleMatches(t *testing.T) {
+	r := strings.NewReader(`/movies/:title
+  X-Movie-Name: :title
+
+/secure/page
+  X-Frame-Options: DENY
+`)
+	file, err := headers.Parse(r)
+	assert.NoError(t, err)
+
+	input, err := url.Parse("https://example.com/movies/jaws")
+	assert.NoError(t, err)
+
+	ok, bindings := file.RuleMatches(0, *input)
+	assert.True(t, ok)
+	assert.Equal(t, map[string]string{":title": "jaws"}, bindings)
+
+	ok, bindings = file.RuleMatches(1, *input)
+	assert.False(t, ok)
+	assert.Nil(t, bindings)
+
+	ok, bindings = file.RuleMatches(1, url.URL{Path: "/secure/page"})
+	assert.True(t, ok)
+	assert.Equal(t, map[string]string{}, bindings)
+
+	for _, index := range []int{-1, 2} {
+		ok, bindings = file.RuleMatches(index, *input)
+		assert.False(t, ok)
+		assert.Nil(t, bindings)
+	}
+}
+
+func Test_File_Match_DetachAll(t *testing.T) {
+	r := strings.NewReader(`/*
+  Content-Security-Policy: default-src 'self';
+  X-Frame-Options: DENY
+
+/*.jpg
+  ! *
+  Cache-Control: max-age=3600
+
+/photo.jpg
+  X-Robots-Tag: noindex
+`)
+	file, err := headers.Parse(r)
+	assert.NoError(t, err)
+
+	tests := []struct {
+		name     string
+		inputURL string
+		expected []string
+	}{
+		{
+			name:     "detached",
+			inputURL: "https://example.com/image.jpg",
+			expected: []string{"Cache-Control: max-age=3600"},
+		},
+		{
+			name:     "later rules still apply",
+			inputURL: "https://example.com/photo.jpg",
+			expected: []string{"Cache-Control: max-age=3600", "X-Robots-Tag: noindex"},
+		},
+		{
+			name:     "not detached",
+			inputURL: "https://example.com/page",
+			expected: []string{"Content-Security-Policy: default-src 'self';", "X-Frame-Options: DENY"},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			input, err := url.Parse(test.inputURL)
+			assert.NoError(t, err)
+
+			out := file.Match(*input)
+			assert.ElementsMatch(t, test.expected, out)
+		})
+	}
+}
+
+func Test_ParseStream(t *testing.T) {
+	input := `/secure/page
+  X-Frame-Options: DENY
+
+/static/*
+  Access-Control-Allow-Origin: *
+
+https://myproject.pages.dev/*
+  X-Robots-Tag: noindex
+`
+	file, err := headers.Parse(strings.NewReader(input))
+	assert.NoError(t, err)
+
+	streamed := headers.File{}
+	err = headers.ParseStream(strings.NewReader(input), func(rule headers.Rule) error {
+		streamed = append(streamed, rule)
+		return nil
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, *file, streamed)
+}
+
+func Test_ParseStream_CallbackError(t *testing.T) {
+	stop := errors.New("stop")
+	calls := 0
+
+	err := headers.ParseStream(strings.NewReader(`/a
+  X-A: a
+
+/b
+  X-B: b
+`), func(rule headers.Rule) error {
+		calls++
+		return stop
+	})
+	assert.ErrorIs(t, err, stop)
+	assert.Equal(t, 1, calls)
+}
+
+func Test_ParseWithOptions_NormalizeScheme(t *testing.T) {
+	tests := []struct {
+		name     string
+		rule     string
+		hasError bool
+	}{
+		{"https", "https://myproject.pages.dev/*", false},
+		{"http", "http://myproject.pages.dev/*", false},
+		{"invalid scheme", "ftp://myproject.pages.dev/*", true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			r := strings.NewReader(test.rule + "\n\tX-Robots-Tag: noindex")
+			file, err := headers.ParseWithOptions(r, headers.ParseOptions{NormalizeScheme: true})
+			if test.hasError {
+				assert.Error(t, err)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, "https", (*file)[0].Pattern.Scheme)
+			assert.Equal(t, "myproject.pages.dev", (*file)[0].Pattern.Host)
+		})
+	}
+}
+
+func Test_File_Affects(t *testing.T) {
+	r := strings.NewReader(`/secure/page
+  X-Frame-Options: DENY
+
+/static/*
+  X-Robots-Tag: nosnippet
+
+https://:subdomain.example.com/*
+  X-Subdomain: :subdomain
+`)
+	file, err := headers.Parse(r)
+	assert.NoError(t, err)
+
+	tests := []struct {
+		inputURL string
+		expected bool
+	}{
+		{"https://example.dev/secure/page", true},
+		{"https://example.dev/static/app.js", true},
+		{"https://www.example.com/anything", true},
+		{"https://example.dev/public/page", false},
+		{"https://example.dev/static/nested/app.js", false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.inputURL, func(t *testing.T) {
+			input, err := url.Parse(test.inputURL)
+			assert.NoError(t, err)
+
+			assert.Equal(t, test.expected, file.Affects(*input))
+		})
+	}
+}
+
+func Test_File_Match_EscapedSplat(t *testing.T) {
+	r := strings.NewReader(`/a\*b
+  X-Literal: true
+
+/c\*/*
+  X-Splat: :splat
+`)
+	file, err := headers.Parse(r)
+	assert.NoError(t, err)
+
+	tests := []struct {
+		name     string
+		inputURL string
+		expected []string
+	}{
+		{
+			name:     "literal asterisk",
+			inputURL: "https://example.com/a*b",
+			expected: []string{"X-Literal: true"},
+		},
+		{
+			name:     "not a splat",
+			inputURL: "https://example.com/axyzb",
+			expected: []string{},
+		},
+		{
+			name:     "literal asterisk with splat",
+			inputURL: "https://example.com/c*/d",
+			expected: []string{"X-Splat: d"},
+		},
+		{
+			name:     "literal asterisk required before splat",
+			inputURL: "https://example.com/cx/d",
+			expected: []string{},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			input, err := url.Parse(test.inputURL)
+			assert.NoError(t, err)
+
+			out := file.Match(*input)
+			assert.ElementsMatch(t, test.expected, out)
+		})
+	}
+}
+
+func Test_File_MatchBreakdown(t *testing.T) {
+	r := strings.NewReader(`/*
+  Content-Security-Policy: default-src 'self';
+  X-Frame-Options: DENY
+
+/*.jpg
+  ! Content-Security-Policy
+  ! X-Never-Set
+`)
+	file, err := headers.Parse(r)
+	assert.NoError(t, err)
+
+	input, err := url.Parse("https://example.com/image.jpg")
+	assert.NoError(t, err)
+
+	set, detached := file.MatchBreakdown(*input)
+	assert.ElementsMatch(t, []string{"X-Frame-Options: DENY"}, set)
+	assert.Equal(t, []string{"Content-Security-Policy"}, detached)
+
+	input, err = url.Parse("https://example.com/page")
+	assert.NoError(t, err)
+
+	set, detached = file.MatchBreakdown(*input)
+	assert.ElementsMatch(t, []string{"Content-Security-Policy: default-src 'self';", "X-Frame-Options: DENY"}, set)
+	assert.Empty(t, detached)
+}
+
+func Test_ParseRule(t *testing.T) {
+	rule, err := headers.ParseRule(`/secure/page
+  X-Frame-Options: DENY
+  ! X-Robots-Tag
+`)
+	assert.NoError(t, err)
+	assert.Equal(t, headers.Rule{Pattern: url.URL{Path: "/secure/page"}, Headers: []headers.Header{
+		{Name: "X-Frame-Options", Value: "DENY"},
+		{Name: "X-Robots-Tag", Detach: true},
+	}, Line: 1}, *rule)
+
+	_, err = headers.ParseRule(`/a
+  X-A: a
+/b
+  X-B: b
+`)
+	assert.EqualError(t, err, "multiple rules in block")
+
+	_, err = headers.ParseRule("# just a comment\n")
+	assert.EqualError(t, err, "no rule in block")
+
+	_, err = headers.ParseRule("  X-A: a\n")
+	assert.Error(t, err)
+}
+
+func Test_Parse_ValueColons(t *testing.T) {
+	r := strings.NewReader(`/*
+  Link: <https://example.com/style.css>; rel="preload"; as="style"
+  Expires: Wed, 21 Oct 2015 07:28:00 GMT
+  X-Time: 12:30:45
+  X-Foo : bar
+`)
+	file, err := headers.Parse(r)
+	assert.NoError(t, err)
+
+	assert.Equal(t, []headers.Header{
+		{Name: "Link", Value: `<https://example.com/style.css>; rel="preload"; as="style"`},
+		{Name: "Expires", Value: "Wed, 21 Oct 2015 07:28:00 GMT"},
+		{Name: "X-Time", Value: "12:30:45"},
+		{Name: "X-Foo", Value: "bar"},
+	}, (*file)[0].Headers)
+}
+
+func Test_Parse_HeaderNameWhitespace(t *testing.T) {
+	r := strings.NewReader(`/*
+  X-Foo  :  bar
+
+/page
+  X-Foo :baz
+  !  X-Robots-Tag  
+`)
+	file, err := headers.Parse(r)
+	assert.NoError(t, err)
+
+	assert.Equal(t, headers.Header{Name: "X-Foo", Value: "bar"}, (*file)[0].Headers[0])
+	assert.Equal(t, headers.Header{Name: "X-Foo", Value: "baz"}, (*file)[1].Headers[0])
+	assert.Equal(t, headers.Header{Name: "X-Robots-Tag", Detach: true}, (*file)[1].Headers[1])
+
+	input, err := url.Parse("https://example.com/page")
+	assert.NoError(t, err)
+
+	assert.Equal(t, []string{"X-Foo: bar,baz"}, file.Match(*input))
+}
+
+func Test_ParseWithOptions_StrictIndentation(t *testing.T) {
+	tests := []struct {
+		name     string
+		input    string
+		errorMsg string
+	}{
+		{
+			name:  "spaces",
+			input: "/a\n  X-A: a\n\n/b\n  X-B: b\n",
+		},
+		{
+			name:  "tabs",
+			input: "/a\n\tX-A: a\n\n/b\n\tX-B: b\n",
+		},
+		{
+			name:     "tabs and spaces",
+			input:    "/a\n  X-A: a\n\n/b\n\tX-B: b\n",
+			errorMsg: "inconsistent indentation at line 5",
+		},
+		{
+			name:     "mixed line",
+			input:    "/a\n\t X-A: a\n",
+			errorMsg: "inconsistent indentation at line 2",
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			_, err := headers.ParseWithOptions(strings.NewReader(test.input), headers.ParseOptions{Strict: true})
+			if test.errorMsg != "" {
+				assert.EqualError(t, err, test.errorMsg)
+			} else {
+				assert.NoError(t, err)
+			}
+
+			// lenient parsing accepts any indentation
+			_, err = headers.Parse(strings.NewReader(test.input))
+			assert.NoError(t, err)
+		})
+	}
+}
+
+func Test_Parse_DetachWithoutName(t *testing.T) {
+	r := strings.NewReader(`/*
+  X-Frame-Options: DENY
+  !
+`)
+	_, err := headers.Parse(r)
+	assert.EqualError(t, err, "detach with no header name at line 3")
+}
+
+func Test_ParseGzip(t *testing.T) {
+	input := `/secure/page
+  X-Frame-Options: DENY
+`
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	_, err := gz.Write([]byte(input))
+	assert.NoError(t, err)
+	assert.NoError(t, gz.Close())
+
+	file, err := headers.ParseGzip(&buf)
+	assert.NoError(t, err)
+
+	expected, err := headers.Parse(strings.NewReader(input))
+	assert.NoError(t, err)
+	assert.Equal(t, expected, file)
+
+	_, err = headers.ParseGzip(strings.NewReader(input))
+	assert.ErrorContains(t, err, "invalid gzip data")
+}
+
+func Test_File_MatchBatch(t *testing.T) {
+	r := strings.NewReader(`/secure/page
+  X-Frame-Options: DENY
+
+/static/*
+  X-Robots-Tag: nosnippet
+`)
+	file, err := headers.Parse(r)
+	assert.NoError(t, err)
+
+	urls := []url.URL{}
+	for _, raw := range []string{
+		"https://example.com/secure/page",
+		"https://example.com/static/app.js",
+		"https://example.com/other",
+	} {
+		input, err := url.Parse(raw)
+		assert.NoError(t, err)
+		urls = append(urls, *input)
+	}
+
+	assert.Equal(t, map[string][]string{
+		"https://example.com/secure/page":   {"X-Frame-Options: DENY"},
+		"https://example.com/static/app.js": {"X-Robots-Tag: nosnippet"},
+		"https://example.com/other":         {},
+	}, file.MatchBatch(urls))
+}
+
+func Test_ParseHeaderLine(t *testing.T) {
+	tests := []struct {
+		name     string
+		line     string
+		expected headers.Header
+		errorMsg string
+	}{
+		{
+			name:     "header",
+			line:     "  X-Frame-Options: DENY",
+			expected: headers.Header{Name: "X-Frame-Options", Value: "DENY"},
+		},
+		{
+			name:     "unindented",
+			line:     "X-Frame-Options: DENY",
+			expected: headers.Header{Name: "X-Frame-Options", Value: "DENY"},
+		},
+		{
+			name:     "detach",
+			line:     "  ! X-Frame-Options",
+			expected: headers.Header{Name: "X-Frame-Options", Detach: true},
+		},
+		{
+			name:     "missing colon",
+			line:     "  X-Frame-Options DENY",
+			errorMsg: `invalid header: "  X-Frame-Options DENY"`,
+		},
+		{
+			name:     "invalid name",
+			line:     "  X Frame Options: DENY",
+			errorMsg: `invalid header name: "X Frame Options"`,
+		},
+		{
+			name:     "empty name",
+			line:     "  : DENY",
+			errorMsg: `invalid header name: ""`,
+		},
+		{
+			name:     "empty detach",
+			line:     "  !",
+			errorMsg: "detach with no header name",
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			header, err := headers.ParseHeaderLine(test.line)
+			if test.errorMsg != "" {
+				assert.EqualError(t, err, test.errorMsg)
+			} else {
+				assert.NoError(t, err)
+				assert.Equal(t, test.expected, header)
+			}
+		})
+	}
+}
+
+func Test_File_Match_LongestMatch(t *testing.T) {
+	r := strings.NewReader(`/a/*
+  X-Rule: splat
+  Cache-Control: no-cache
+
+/a/b
+  X-Rule: exact
+
+/a/:name
+  X-Rule: placeholder
+
+/a/b
+  X-Rule: duplicate
+`)
+	file, err := headers.Parse(r)
+	assert.NoError(t, err)
+
+	tests := []struct {
+		name     string
+		inputURL string
+		combined []string
+		longest  []string
+	}{
+		{
+			name:     "exact",
+			inputURL: "https://example.com/a/b",
+			combined: []string{"X-Rule: splat,exact,placeholder,duplicate", "Cache-Control: no-cache"},
+			longest:  []string{"X-Rule: exact"},
+		},
+		{
+			name:     "splat",
+			inputURL: "https://example.com/a/c",
+			combined: []string{"X-Rule: splat,placeholder", "Cache-Control: no-cache"},
+			longest:  []string{"X-Rule: splat", "Cache-Control: no-cache"},
+		},
+		{
+			name:     "no match",
+			inputURL: "https://example.com/b",
+			combined: []string{},
+			longest:  []string{},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			input, err := url.Parse(test.inputURL)
+			assert.NoError(t, err)
+
+			assert.ElementsMatch(t, test.combined, file.Match(*input))
+			assert.ElementsMatch(t, test.longest, file.MatchWithOptions(*input, headers.MatchOptions{LongestMatch: true}))
+		})
+	}
+}
+
+func Test_Parse_MultipleHostWildcards(t *testing.T) {
+	_, err := headers.Parse(strings.NewReader("https://*.*.example.com/*\n  X-Robots-Tag: noindex\n"))
+	assert.EqualError(t, err, `multiple wildcards in host not supported: "https://*.*.example.com/*"`)
+}
+
+func Test_File_Match_CleanPath(t *testing.T) {
+	r := strings.NewReader(`/a/b
+  X-Exact: true
+
+/a/b/
+  X-Trailing: true
+
+/files/*
+  X-File: :splat
+`)
+	file, err := headers.Parse(r)
+	assert.NoError(t, err)
+
+	tests := []struct {
+		name     string
+		inputURL string
+		cleaned  []string
+		raw      []string
+	}{
+		{
+			name:     "dot segment",
+			inputURL: "https://example.com/a/./b",
+			cleaned:  []string{"X-Exact: true"},
+			raw:      []string{},
+		},
+		{
+			name:     "double slash",
+			inputURL: "https://example.com//a/b",
+			cleaned:  []string{"X-Exact: true"},
+			raw:      []string{},
+		},
+		{
+			name:     "parent segment",
+			inputURL: "https://example.com/a/c/../b",
+			cleaned:  []string{"X-Exact: true"},
+			raw:      []string{},
+		},
+		{
+			name:     "trailing slash",
+			inputURL: "https://example.com/a//b/",
+			cleaned:  []string{"X-Trailing: true"},
+			raw:      []string{},
+		},
+		{
+			name:     "splat capture",
+			inputURL: "https://example.com/files/x/../report.pdf",
+			cleaned:  []string{"X-File: report.pdf"},
+			raw:      []string{},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			input, err := url.Parse(test.inputURL)
+			assert.NoError(t, err)
+
+			assert.ElementsMatch(t, test.raw, file.Match(*input))
+			assert.ElementsMatch(t, test.cleaned, file.MatchWithOptions(*input, headers.MatchOptions{CleanPath: true}))
+		})
+	}
+}
+
+func Test_File_MatchDetail(t *testing.T) {
+	r := strings.NewReader(`/download/*
+  Content-Disposition: attachment
+
+/download/:file
+  X-File: :file
+
+/download/a.zip
+  X-Exact: true
+
+https://:subdomain.example.com/*
+  X-Subdomain: :subdomain
+`)
+	file, err := headers.Parse(r)
+	assert.NoError(t, err)
+
+	input, err := url.Parse("https://downloads.example.com/download/a.zip")
+	assert.NoError(t, err)
+
+	matches := file.MatchDetail(*input)
+	assert.Len(t, matches, 4)
+	assert.Equal(t, 0, matches[0].Index)
+	assert.Equal(t, map[string]string{":splat": "a.zip"}, matches[0].Bindings)
+	assert.Equal(t, map[string]string{":file": "a.zip"}, matches[1].Bindings)
+	assert.Equal(t, map[string]string{}, matches[2].Bindings)
+	assert.Equal(t, (*file)[3], matches[3].Rule)
+	// the values captured from both the host and the path are bound
+	assert.Equal(t, map[string]string{":subdomain": "downloads", ":splat": "download/a.zip"}, matches[3].Bindings)
+
+	// splats do not capture across a "/"
+	input, err = url.Parse("https://example.com/download/a/b.zip")
+	assert.NoError(t, err)
+	assert.Empty(t, file.MatchDetail(*input))
+}
+
+func Test_File_Explain(t *testing.T) {
+	r := strings.NewReader(`https://example.com/admin
+  X-Admin: true
+
+https://other.example.com/*
+  X-Other: true
+
+/admin
+  X-Admin-Path: true
+
+/public/*
+  X-Public: true
+`)
+	file, err := headers.Parse(r)
+	assert.NoError(t, err)
+
+	input, err := url.Parse("https://example.com/public")
+	assert.NoError(t, err)
+
+	var reasons []string
+	var matched []bool
+	for i, explanation := range file.Explain(*input) {
+		assert.Equal(t, i, explanation.Index)
+		assert.Equal(t, (*file)[i], explanation.Rule)
+		reasons = append(reasons, explanation.Reason)
+		matched = append(matched, explanation.Matched)
+	}
+	assert.Equal(t, []bool{false, false, false, false}, matched)
+	assert.Equal(t, []string{
+		// the host matched, but not the path, so the rule did not apply
+		`host matched, path differed: "/admin" vs "/public"`,
+		`host differed: "other.example.com" vs "example.com"`,
+		`path differed: "/admin" vs "/public"`,
+		`path differed: "/public/*" vs "/public"`,
+	}, reasons)
+
+	explanations := file.Explain(url.URL{Path: "/public/index.html"})
+	assert.Equal(t, `host differed: "example.com" vs no host`, explanations[0].Reason)
+	assert.True(t, explanations[3].Matched)
+	assert.Equal(t, "path matched", explanations[3].Reason)
+
+	admin, err := url.Parse("https://example.com/admin")
+	assert.NoError(t, err)
+	explanations = file.Explain(*admin)
+	assert.True(t, explanations[0].Matched)
+	assert.Equal(t, "host and path matched", explanations[0].Reason)
+}
+
+func Test_Parse_WildcardHost(t *testing.T) {
+	tests := []struct {
+		rule     string
+		expected url.URL
+	}{
+		{
+			"https://*.example.com/*",
+			url.URL{Scheme: "https", Host: "*.example.com", Path: "/*"},
+		},
+		{
+			"https://api-*.example.com/v1/*",
+			url.URL{Scheme: "https", Host: "api-*.example.com", Path: "/v1/*"},
+		},
+		{
+			"https://:subdomain.example.com/:page",
+			url.URL{Scheme: "https", Host: ":subdomain.example.com", Path: "/:page"},
+		},
+		{
+			"https://*/*.example.com",
+			url.URL{Scheme: "https", Host: "*", Path: "/*.example.com"},
+		},
+		{
+			"https://s/page",
+			url.URL{Scheme: "https", Host: "s", Path: "/page"},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.rule, func(t *testing.T) {
+			rule, err := headers.ParseRule(test.rule + "\n  X-Robots-Tag: noindex")
+			assert.NoError(t, err)
+			assert.Equal(t, test.expected, rule.Pattern)
+		})
+	}
+}
+
+func Test_File_Match_DetachValue(t *testing.T) {
+	r := strings.NewReader(`/blog/:page
+  X-Robots-Tag: nosnippet
+
+/blog/*
+  X-Robots-Tag: noindex
+  Cache-Control: max-age=60
+
+/blog/public
+  ! X-Robots-Tag: noindex
+  ! Cache-Control: max-age=60
+  ! X-Never-Set: value
+`)
+	file, err := headers.Parse(r)
+	assert.NoError(t, err)
+
+	assert.Equal(t, []headers.Header{
+		{Name: "X-Robots-Tag", Value: "noindex", Detach: true},
+		{Name: "Cache-Control", Value: "max-age=60", Detach: true},
+		{Name: "X-Never-Set", Value: "value", Detach: true},
+	}, (*file)[2].Headers)
+
+	tests := []struct {
+		inputURL string
+		expected []string
+	}{
+		{"https://example.com/blog/post", []string{"X-Robots-Tag: nosnippet,noindex", "Cache-Control: max-age=60"}},
+		{"https://example.com/blog/public", []string{"X-Robots-Tag: nosnippet"}},
+	}
+	for _, test := range tests {
+		t.Run(test.inputURL, func(t *testing.T) {
+			input, err := url.Parse(test.inputURL)
+			assert.NoError(t, err)
+
+			assert.ElementsMatch(t, test.expected, file.Match(*input))
+		})
+	}
+}
+
+func Test_Parse_ValueTabs(t *testing.T) {
+	r := strings.NewReader("/*\n\tX-Tabbed:\t\tone\ttwo\t\tthree\t\n")
+	file, err := headers.ParseWithOptions(r, headers.ParseOptions{Strict: true})
+	assert.NoError(t, err)
+
+	assert.Equal(t, headers.Header{Name: "X-Tabbed", Value: "one\ttwo\t\tthree"}, (*file)[0].Headers[0])
+}
+
+func Test_File_RulesSetting(t *testing.T) {
+	r := strings.NewReader(`/*
+  Content-Security-Policy: default-src 'self';
+
+/admin/*
+  content-security-policy: default-src 'none';
+  X-Frame-Options: DENY
+
+/*.jpg
+  ! Content-Security-Policy
+`)
+	file, err := headers.Parse(r)
+	assert.NoError(t, err)
+
+	rules := file.RulesSetting("Content-Security-Policy")
+	assert.Equal(t, []headers.Rule{(*file)[0], (*file)[1]}, rules)
+
+	assert.Empty(t, file.RulesSetting("X-Robots-Tag"))
+}
+
+func Test_File_MatchMap(t *testing.T) {
+	r := strings.NewReader(`/static/*
+  Access-Control-Allow-Origin: *
+  X-Robots-Tag: nosnippet
+
+https://myproject.pages.dev/*
+  X-Robots-Tag: noindex
+  X-Frame-Options: DENY
+
+/static/*.png
+  ! X-Frame-Options
+`)
+	file, err := headers.Parse(r)
+	assert.NoError(t, err)
+
+	input, err := url.Parse("https://myproject.pages.dev/static/image.png")
+	assert.NoError(t, err)
+
+	assert.Equal(t, map[string]string{
+		"Access-Control-Allow-Origin": "*",
+		"X-Robots-Tag":                "nosnippet,noindex",
+	}, file.MatchMap(*input))
+}
+
+func Test_File_MatchMap_SetCookie(t *testing.T) {
+	r := strings.NewReader(`/*
+  Set-Cookie: a=1
+
+/account
+  Set-Cookie: b=2
+`)
+	file, err := headers.Parse(r)
+	assert.NoError(t, err)
+
+	input, err := url.Parse("/account")
+	assert.NoError(t, err)
+
+	// a map holds one value per name, so cookies are joined, unlike with Match
+	assert.Equal(t, map[string]string{"Set-Cookie": "a=1,b=2"}, file.MatchMap(*input))
+	assert.Equal(t, []string{"Set-Cookie: a=1", "Set-Cookie: b=2"}, file.Match(*input))
+}
+
+func Test_File_Match_DetachGlob(t *testing.T) {
+	r := strings.NewReader(`/*
+  X-Frame-Options: DENY
+  X-Robots-Tag: noindex
+  x-lowercase: true
+  Referrer-Policy: no-referrer
+
+/*.jpg
+  ! X-*
+  X-Image: true
+
+/*.png
+  ! *-Policy
+`)
+	file, err := headers.Parse(r)
+	assert.NoError(t, err)
+
+	tests := []struct {
+		inputURL string
+		expected []string
+	}{
+		{"https://example.com/image.jpg", []string{"Referrer-Policy: no-referrer", "X-Image: true"}},
+		{"https://example.com/image.png", []string{"X-Frame-Options: DENY", "X-Robots-Tag: noindex", "x-lowercase: true"}},
+	}
+	for _, test := range tests {
+		t.Run(test.inputURL, func(t *testing.T) {
+			input, err := url.Parse(test.inputURL)
+			assert.NoError(t, err)
+
+			assert.ElementsMatch(t, test.expected, file.Match(*input))
+		})
+	}
+
+	input, err := url.Parse("https://example.com/image.jpg")
+	assert.NoError(t, err)
+	_, detached := file.MatchBreakdown(*input)
+	assert.Equal(t, []string{"X-Frame-Options", "X-Robots-Tag", "x-lowercase"}, detached)
+
+	// a wildcard detach removes whole headers, so a value is rejected
+	_, err = headers.Parse(strings.NewReader("/*\n  ! X-*: 1\n"))
+	assert.EqualError(t, err, `wildcard detach "X-*" cannot have a value at line 2`)
+	_, err = headers.ParseHeaderLine("! X-*: 1")
+	assert.Error(t, err)
+}
+
+func Test_File_MatchOK(t *testing.T) {
+	r := strings.NewReader(`/*
+  Content-Security-Policy: default-src 'self';
+
+/*.jpg
+  ! Content-Security-Policy
+`)
+	file, err := headers.Parse(r)
+	assert.NoError(t, err)
+
+	tests := []struct {
+		name     string
+		inputURL string
+		expected []string
+		ok       bool
+	}{
+		{"matched", "https://example.com/page", []string{"Content-Security-Policy: default-src 'self';"}, true},
+		{"all detached", "https://example.com/image.jpg", []string{}, true},
+		{"no match", "https://example.com/nested/page", []string{}, false},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			input, err := url.Parse(test.inputURL)
+			assert.NoError(t, err)
+
+			out, ok := file.MatchOK(*input)
+			assert.Equal(t, test.expected, out)
+			assert.Equal(t, test.ok, ok)
+		})
+	}
+}
+
+func Test_Parse_UppercaseScheme(t *testing.T) {
+	rule, err := headers.ParseRule("HTTPS://example.com/*\n  X-Frame-Options: DENY\n")
+	assert.NoError(t, err)
+	assert.Equal(t, url.URL{Scheme: "https", Host: "example.com", Path: "/*"}, rule.Pattern)
+
+	file, err := headers.ParseWithOptions(strings.NewReader("Http://example.com/*\n  X-Frame-Options: DENY\n"), headers.ParseOptions{NormalizeScheme: true})
+	assert.NoError(t, err)
+	assert.Equal(t, "https", (*file)[0].Pattern.Scheme)
+
+	input, err := url.Parse("https://example.com/page")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"X-Frame-Options: DENY"}, headers.File{*rule}.Match(*input))
+}
+
+func Test_Parse_EmbeddedScheme(t *testing.T) {
+	tests := []struct {
+		rule     string
+		expected url.URL
+	}{
+		{
+			"/redirect/https://other.com",
+			url.URL{Path: "/redirect/https://other.com"},
+		},
+		{
+			"https://example.com/go/http://x",
+			url.URL{Scheme: "https", Host: "example.com", Path: "/go/http://x"},
+		},
+		{
+			"https://example.com/example.com/*",
+			url.URL{Scheme: "https", Host: "example.com", Path: "/example.com/*"},
+		},
+		{
+			"https://tps/https://tps/",
+			url.URL{Scheme: "https", Host: "tps", Path: "/https://tps/"},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.rule, func(t *testing.T) {
+			rule, err := headers.ParseRule(test.rule + "\n  X-Robots-Tag: noindex")
+			assert.NoError(t, err)
+			assert.Equal(t, test.expected, rule.Pattern)
+			assert.Equal(t, test.rule+"\n  X-Robots-Tag: noindex\n", rule.String())
+		})
+	}
+
+	file, err := headers.Parse(strings.NewReader("/redirect/https://other.com\n  X-Redirect: true\n"))
+	assert.NoError(t, err)
+	input, err := url.Parse("https://example.com/redirect/https://other.com")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"X-Redirect: true"}, file.Match(*input))
+}
+
+func Test_File_MatchRaw(t *testing.T) {
+	r := strings.NewReader(`/secure/*
+  X-Robots-Tag: nosnippet
+  Content-Security-Policy: default-src 'self';
+
+/secure/page
+  X-Frame-Options: DENY
+  X-Robots-Tag: noindex
+`)
+	file, err := headers.Parse(r)
+	assert.NoError(t, err)
+
+	input, err := url.Parse("https://example.com/secure/page")
+	assert.NoError(t, err)
+
+	assert.Equal(t, "Content-Security-Policy: default-src 'self';\r\n"+
+		"X-Frame-Options: DENY\r\n"+
+		"X-Robots-Tag: nosnippet,noindex\r\n", file.MatchRaw(*input))
+
+	input, err = url.Parse("https://example.com/nested/page")
+	assert.NoError(t, err)
+	assert.Equal(t, "", file.MatchRaw(*input))
+
+	// sorted by name, case-insensitively, rather than by whole line
+	file, err = headers.Parse(strings.NewReader("/*\n  A-B: 2\n  a-c: 3\n  A: 1\n"))
+	assert.NoError(t, err)
+	assert.Equal(t, "A: 1\r\nA-B: 2\r\na-c: 3\r\n", file.MatchRaw(url.URL{Path: "/"}))
+}
+
+func Test_File_Match_GreedySplat(t *testing.T) {
+	r := strings.NewReader(`/files/**
+  X-Greedy: :splat
+
+/files/*
+  X-Splat: :splat
+
+/assets/**.js
+  X-Script: :splat
+`)
+	file, err := headers.Parse(r)
+	assert.NoError(t, err)
+
+	tests := []struct {
+		name     string
+		inputURL string
+		expected []string
+	}{
+		{"single segment", "https://example.com/files/x", []string{"X-Greedy: x", "X-Splat: x"}},
+		{"nested", "https://example.com/files/a/b/c", []string{"X-Greedy: a/b/c"}},
+		{"greedy with suffix", "https://example.com/assets/js/app.js", []string{"X-Script: js/app"}},
+		{"greedy suffix mismatch", "https://example.com/assets/js/app.css", []string{}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			input, err := url.Parse(test.inputURL)
+			assert.NoError(t, err)
+
+			assert.ElementsMatch(t, test.expected, file.Match(*input))
+		})
+	}
+}
+
+func Test_ParseWithOptions_AllowPatternContinuation(t *testing.T) {
+	input := `# wrapped
+https://myproject.pages.dev/a/very/long/\
+    path/to/\
+    :page
+  X-Page: :page
+
+/short
+  X-Short: true
+`
+	file, err := headers.ParseWithOptions(strings.NewReader(input), headers.ParseOptions{AllowPatternContinuation: true})
+	assert.NoError(t, err)
+
+	assert.Equal(t, headers.File{
+		{
+			Pattern: url.URL{Scheme: "https", Host: "myproject.pages.dev", Path: "/a/very/long/path/to/:page"},
+			Headers: []headers.Header{{Name: "X-Page", Value: ":page"}},
+			Line:    2,
+		},
+		{
+			Pattern: url.URL{Path: "/short"},
+			Headers: []headers.Header{{Name: "X-Short", Value: "true"}},
+			Line:    7,
+		},
+	}, *file)
+
+	// without the option the continuation is parsed as a header
+	_, err = headers.Parse(strings.NewReader(input))
+	assert.Error(t, err)
+
+	_, err = headers.ParseWithOptions(strings.NewReader("/a/\\\n"), headers.ParseOptions{AllowPatternContinuation: true})
+	assert.EqualError(t, err, "pattern continued at line 1 has no following line")
+
+	// a continuation never crosses a blank line or comment
+	_, err = headers.ParseWithOptions(strings.NewReader("/foo\\\n\n# c\nbar\n  X: 1\n"), headers.ParseOptions{AllowPatternContinuation: true})
+	assert.EqualError(t, err, "pattern continued at line 1 is followed by a blank line or comment")
+
+	// nor swallows a header, indented as a continuation may be
+	for _, opts := range []headers.ParseOptions{
+		{AllowPatternContinuation: true},
+		{AllowPatternContinuation: true, Strict: true},
+	} {
+		_, err = headers.ParseWithOptions(strings.NewReader("/foo\\\n  X: 1\n"), opts)
+		assert.EqualError(t, err, "pattern continued at line 1 is followed by a header at line 2")
+		_, err = headers.ParseWithOptions(strings.NewReader("/foo\\\n  ! X\n"), opts)
+		assert.EqualError(t, err, "pattern continued at line 1 is followed by a header at line 2")
+	}
+}
+
+func Test_File_Hosts(t *testing.T) {
+	r := strings.NewReader(`https://myproject.pages.dev/*
+  X-Robots-Tag: noindex
+
+https://example.com/*
+  X-Frame-Options: DENY
+
+/secure/page
+  X-Frame-Options: DENY
+
+https://:subdomain.example.com/*
+  X-Subdomain: :subdomain
+
+https://*.example.org/*
+  X-Splat: :splat
+
+https://example.com/admin
+  X-Admin: true
+`)
+	file, err := headers.Parse(r)
+	assert.NoError(t, err)
+
+	assert.Equal(t, []string{
+		"*.example.org",
+		":subdomain.example.com",
+		"example.com",
+		"myproject.pages.dev",
+	}, file.Hosts())
+
+	assert.Empty(t, headers.File{}.Hosts())
+}
+
+func Test_File_Placeholders(t *testing.T) {
+	r := strings.NewReader(`https://:subdomain.example.com/*
+  X-Subdomain: :subdomain
+
+/movies/:title
+  X-Movie-Name: You are watching ":title"
+
+https://:tenant.example.org/:lang/:title
+  X-Tenant: :tenant
+  X-Lang: :lang
+
+/static/*
+  Cache-Control: max-age=3600
+`)
+	file, err := headers.Parse(r)
+	assert.NoError(t, err)
+
+	assert.Equal(t, []string{"lang", "subdomain", "tenant", "title"}, file.Placeholders())
+
+	assert.Empty(t, headers.File{}.Placeholders())
+}
+
+// Detaches are resolved in order, so they only remove values set before them.
+// TODO: Confirm via testing with Cloudflare directly.
+func Test_Flatten_DetachOrdering(t *testing.T) {
+	tests := []struct {
+		name     string
+		headers  []headers.Header
+		expected []string
+	}{
+		{
+			name: "set, detach, set",
+			headers: []headers.Header{
+				{Name: "X-Foo", Value: "a"},
+				{Name: "X-Foo", Detach: true},
+				{Name: "X-Foo", Value: "b"},
+			},
+			expected: []string{"X-Foo: b"},
+		},
+		{
+			name: "detach, set",
+			headers: []headers.Header{
+				{Name: "X-Foo", Detach: true},
+				{Name: "X-Foo", Value: "a"},
+			},
+			expected: []string{"X-Foo: a"},
+		},
+		{
+			name: "set, set, detach",
+			headers: []headers.Header{
+				{Name: "X-Foo", Value: "a"},
+				{Name: "X-Foo", Value: "b"},
+				{Name: "X-Foo", Detach: true},
+			},
+			expected: []string{},
+		},
+		{
+			name: "set, detach, set, set",
+			headers: []headers.Header{
+				{Name: "X-Foo", Value: "a"},
+				{Name: "X-Foo", Detach: true},
+				{Name: "X-Foo", Value: "b"},
+				{Name: "X-Foo", Value: "c"},
+			},
+			expected: []string{"X-Foo: b,c"},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			assert.Equal(t, test.expected, headers.Flatten(test.headers))
+		})
+	}
+}
+
+func Test_File_Match_DetachOrdering(t *testing.T) {
+	r := strings.NewReader(`/*
+  X-Foo: a
+
+/*.jpg
+  ! X-Foo
+
+/image.jpg
+  X-Foo: b
+`)
+	file, err := headers.Parse(r)
+	assert.NoError(t, err)
+
+	input, err := url.Parse("https://example.com/image.jpg")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"X-Foo: b"}, file.Match(*input))
+
+	input, err = url.Parse("https://example.com/other.jpg")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{}, file.Match(*input))
+}
+
+func Test_ParseWithOptions_RejectInvalidValues(t *testing.T) {
+	tests := []struct {
+		name  string
+		input string
+	}{
+		{"carriage return", "/*\n  X-Split: a\rSet-Cookie: b\n"},
+		{"nul", "/*\n  X-Nul: a\x00b\n"},
+		{"nul in detach value", "/*\n  ! X-Nul: a\x00b\n"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			_, err := headers.ParseWithOptions(strings.NewReader(test.input), headers.ParseOptions{RejectInvalidValues: true})
+			assert.EqualError(t, err, "invalid character in header value at line 2")
+
+			// without the option the value is kept as is
+			_, err = headers.Parse(strings.NewReader(test.input))
+			assert.NoError(t, err)
+		})
+	}
+
+	_, err := headers.ParseWithOptions(strings.NewReader("/*\n  X-Tab: a\tb\n"), headers.ParseOptions{RejectInvalidValues: true})
+	assert.NoError(t, err)
+}
+
+// Patterns and input URLs are both compared in their decoded form.
+func Test_File_Match_EncodedSpaces(t *testing.T) {
+	r := strings.NewReader(`/my%20page
+  X-Encoded: true
+
+/my other page
+  X-Literal: true
+
+/docs/*
+  X-Doc: :splat
+
+/movies/:title
+  X-Movie: :title
+`)
+	file, err := headers.Parse(r)
+	assert.NoError(t, err)
+
+	tests := []struct {
+		url      string
+		expected []string
+	}{
+		{"https://example.com/my%20page", []string{"X-Encoded: true"}},
+		{"https://example.com/my page", []string{"X-Encoded: true"}},
+		{"https://example.com/my+page", []string{}},
+		{"https://example.com/my%20other%20page", []string{"X-Literal: true"}},
+		{"https://example.com/docs/getting%20started", []string{"X-Doc: getting started"}},
+		{"https://example.com/movies/the%20matrix", []string{"X-Movie: the matrix"}},
+	}
+	for _, test := range tests {
+		t.Run(test.url, func(t *testing.T) {
+			input, err := url.Parse(test.url)
+			assert.NoError(t, err)
+			assert.ElementsMatch(t, test.expected, file.Match(*input))
+		})
+	}
+}
+
+func Test_ParseWithOptions_MaxValueLength(t *testing.T) {
+	input := `/*
+  X-Short: abc
+  X-Accent: ééééé
+
+/long
+  X-Long: abcdef
+`
+	_, err := headers.ParseWithOptions(strings.NewReader(input), headers.ParseOptions{MaxValueLength: 5})
+	assert.EqualError(t, err, `value of header "X-Long" exceeds 5 character limit at line 6`)
+
+	file, err := headers.ParseWithOptions(strings.NewReader(input), headers.ParseOptions{MaxValueLength: 6})
+	assert.NoError(t, err)
+	assert.Len(t, *file, 2)
+
+	// unlimited by default
+	_, err = headers.Parse(strings.NewReader(input))
+	assert.NoError(t, err)
+}
+
+func Test_Parse_MissingSchemeOrHost(t *testing.T) {
+	_, err := headers.Parse(strings.NewReader("https:\n  X-Test: true\n"))
+	assert.EqualError(t, err, `missing host in pattern: "https:"`)
+
+	_, err = headers.Parse(strings.NewReader("//example.com/*\n  X-Test: true\n"))
+	assert.EqualError(t, err, `missing scheme in pattern: "//example.com/*"`)
+}
+
+func Test_ParseWithOptions_DedupWithinRule(t *testing.T) {
+	input := `/*
+  X-Foo: a
+  X-Other: true
+  x-foo: b
+`
+	tests := []struct {
+		mode     string
+		expected []headers.Header
+	}{
+		{"", []headers.Header{{Name: "X-Foo", Value: "a"}, {Name: "X-Other", Value: "true"}, {Name: "x-foo", Value: "b"}}},
+		{headers.DedupMerge, []headers.Header{{Name: "X-Foo", Value: "a"}, {Name: "X-Other", Value: "true"}, {Name: "x-foo", Value: "b"}}},
+		{headers.DedupLastWins, []headers.Header{{Name: "X-Other", Value: "true"}, {Name: "x-foo", Value: "b"}}},
+		{headers.DedupFirstWins, []headers.Header{{Name: "X-Foo", Value: "a"}, {Name: "X-Other", Value: "true"}}},
+	}
+	for _, test := range tests {
+		t.Run(test.mode, func(t *testing.T) {
+			file, err := headers.ParseWithOptions(strings.NewReader(input), headers.ParseOptions{DedupWithinRule: test.mode})
+			assert.NoError(t, err)
+			assert.Equal(t, test.expected, (*file)[0].Headers)
+		})
+	}
+
+	t.Run(headers.DedupError, func(t *testing.T) {
+		_, err := headers.ParseWithOptions(strings.NewReader(input), headers.ParseOptions{DedupWithinRule: headers.DedupError})
+		assert.EqualError(t, err, `duplicate header "x-foo" at line 4`)
+	})
+
+	t.Run("detaches are not duplicates", func(t *testing.T) {
+		_, err := headers.ParseWithOptions(strings.NewReader("/*\n  X-Foo: a\n  ! X-Foo\n"), headers.ParseOptions{DedupWithinRule: headers.DedupError})
+		assert.NoError(t, err)
+	})
+
+	t.Run("unknown mode", func(t *testing.T) {
+		_, err := headers.ParseWithOptions(strings.NewReader(input), headers.ParseOptions{DedupWithinRule: "newest"})
+		assert.EqualError(t, err, `unknown DedupWithinRule mode: "newest"`)
+	})
+}
+
+func Test_File_Match_PathOnly(t *testing.T) {
+	r := strings.NewReader(`/*
+  X-Any: true
+
+/secure/:page
+  X-Page: :page
+
+https://example.com/*
+  X-Host: true
+
+https://*/*
+  X-Splat-Host: :splat
+
+https://:subdomain/*
+  X-Placeholder-Host: :subdomain
+`)
+	file, err := headers.Parse(r)
+	assert.NoError(t, err)
+
+	assert.ElementsMatch(t, []string{"X-Any: true"}, file.Match(url.URL{Path: "/"}))
+	assert.ElementsMatch(t, []string{"X-Page: login"}, file.Match(url.URL{Path: "/secure/login"}))
+	assert.ElementsMatch(t, []string{}, file.Match(url.URL{}))
+
+	// with a host, the host rules apply too
+	assert.ElementsMatch(t, []string{
+		"X-Any: true",
+		"X-Host: true",
+	}, file.Match(url.URL{Scheme: "https", Host: "example.com", Path: "/"}))
+	assert.ElementsMatch(t, []string{
+		"X-Any: true",
+		"X-Splat-Host: localhost",
+		"X-Placeholder-Host: localhost",
+	}, file.Match(url.URL{Scheme: "https", Host: "localhost", Path: "/"}))
+}
+
+func Test_ParseWithOptions_MergeDuplicatePatterns(t *testing.T) {
+	input := `/admin
+  X-Frame-Options: DENY
+  X-Robots-Tag: noindex
+
+/admin
+  ! X-Robots-Tag
+  Cache-Control: no-store
+
+/public
+  X-Public: true
+
+/admin
+  X-Later: true
+`
+	file, err := headers.ParseWithOptions(strings.NewReader(input), headers.ParseOptions{MergeDuplicatePatterns: true})
+	assert.NoError(t, err)
+
+	assert.Equal(t, headers.File{
+		{
+			Pattern: url.URL{Path: "/admin"},
+			Headers: []headers.Header{
+				{Name: "X-Frame-Options", Value: "DENY"},
+				{Name: "X-Robots-Tag", Value: "noindex"},
+				{Name: "X-Robots-Tag", Detach: true},
+				{Name: "Cache-Control", Value: "no-store"},
+			},
+			Line: 1,
+		},
+		{
+			Pattern: url.URL{Path: "/public"},
+			Headers: []headers.Header{{Name: "X-Public", Value: "true"}},
+			Line:    9,
+		},
+		// not adjacent, so merging would change precedence
+		{
+			Pattern: url.URL{Path: "/admin"},
+			Headers: []headers.Header{{Name: "X-Later", Value: "true"}},
+			Line:    12,
+		},
+	}, *file)
+
+	file, err = headers.Parse(strings.NewReader(input))
+	assert.NoError(t, err)
+	assert.Len(t, *file, 4)
+}
+
+func Test_File_Match_DetachCase(t *testing.T) {
+	r := strings.NewReader(`/*
+  Content-Security-Policy: default-src 'self';
+  X-Robots-Tag: nosnippet
+  Vary: Accept
+
+/*.jpg
+  ! content-security-policy
+  x-robots-tag: noindex
+  ! VARY: Accept
+`)
+	file, err := headers.Parse(r)
+	assert.NoError(t, err)
+
+	input, err := url.Parse("https://example.com/image.jpg")
+	assert.NoError(t, err)
+
+	// values for the same header in different cases are kept together, under
+	// the name first used
+	assert.ElementsMatch(t, []string{"X-Robots-Tag: nosnippet,noindex"}, file.Match(*input))
+}
+
+// Patterns are stored decoded in Path, with RawPath always empty, whatever the
+// characters used.
+func Test_Parse_PatternRepresentation(t *testing.T) {
+	tests := []struct {
+		pattern  string
+		expected url.URL
+	}{
+		{"/secure/page", url.URL{Path: "/secure/page"}},
+		{"/static/*", url.URL{Path: "/static/*"}},
+		{"/a\\*b", url.URL{Path: "/a\\*b"}},
+		{"/movies/:title", url.URL{Path: "/movies/:title"}},
+		{"/my%20page", url.URL{Path: "/my page"}},
+		{"/my page", url.URL{Path: "/my page"}},
+		{"/a%2Fb", url.URL{Path: "/a/b"}},
+		{"/100%25", url.URL{Path: "/100%"}},
+		{"https://example.com/*", url.URL{Scheme: "https", Host: "example.com", Path: "/*"}},
+	}
+	for _, test := range tests {
+		t.Run(test.pattern, func(t *testing.T) {
+			file, err := headers.Parse(strings.NewReader(test.pattern + "\n  X-Test: true\n"))
+			assert.NoError(t, err)
+			assert.Equal(t, test.expected, (*file)[0].Pattern)
+
+			// and formatting gives a pattern which parses the same
+			reparsed, err := headers.Parse(strings.NewReader(file.String()))
+			assert.NoError(t, err)
+			assert.Equal(t, test.expected, (*reparsed)[0].Pattern)
+		})
+	}
+}
+
+func Test_File_MatchResolved(t *testing.T) {
+	r := strings.NewReader(`/movies/*
+  X-Robots-Tag: nosnippet
+  Cache-Control: max-age=60
+  Link: </a.css>; rel=preload
+  X-Frame-Options: DENY
+
+/movies/:title.html
+  ! Cache-Control
+  Link: </:title.css>; rel=preload
+  ! X-Frame-Options
+  X-Robots-Tag: noindex
+  Cache-Control: no-store
+`)
+	file, err := headers.Parse(r)
+	assert.NoError(t, err)
+
+	input, err := url.Parse("https://example.com/movies/alien.html")
+	assert.NoError(t, err)
+
+	assert.Equal(t, []headers.Header{
+		{Name: "X-Robots-Tag", Value: "nosnippet"},
+		{Name: "X-Robots-Tag", Value: "noindex"},
+		{Name: "Link", Value: "</a.css>; rel=preload"},
+		{Name: "Link", Value: "</alien.css>; rel=preload"},
+		{Name: "Cache-Control", Value: "no-store"},
+	}, file.MatchResolved(*input))
+
+	input, err = url.Parse("https://example.com/movies/alien")
+	assert.NoError(t, err)
+
+	assert.Equal(t, []headers.Header{
+		{Name: "X-Robots-Tag", Value: "nosnippet"},
+		{Name: "Cache-Control", Value: "max-age=60"},
+		{Name: "Link", Value: "</a.css>; rel=preload"},
+		{Name: "X-Frame-Options", Value: "DENY"},
+	}, file.MatchResolved(*input))
+
+	assert.Empty(t, headers.File{}.MatchResolved(*input))
+}
+
+func Test_Parse_InvalidPattern(t *testing.T) {
+	_, err := headers.Parse(strings.NewReader("/*\n  X-Test: true\n\n/bad\x7fpath\n  X-Test: true\n"))
+	assert.EqualError(t, err, `line 4: invalid pattern "/bad\x7fpath": parse "/bad\x7fpath": net/url: invalid control character in URL`)
+
+	var urlErr *url.Error
+	assert.ErrorAs(t, err, &urlErr)
+
+	// a percent sign which does not start an escape is taken literally
+	file, err := headers.Parse(strings.NewReader("/50%off\n  X-Sale: true\n\n/100%25\n  X-Escaped: true\n"))
+	assert.NoError(t, err)
+	assert.Equal(t, "/50%off", (*file)[0].Pattern.Path)
+	assert.Equal(t, "/100%", (*file)[1].Pattern.Path)
+}
+
+func Test_Parse_Redirect(t *testing.T) {
+	tests := []struct {
+		name  string
+		input string
+		err   string
+	}{
+		{
+			"source, destination and status",
+			"/old /new 301\n",
+			`line looks like a redirect, which belongs in a _redirects file, use a _redirects parser for "/old /new 301" at line 1`,
+		},
+		{
+			"source and destination",
+			"/*\n  X-Test: true\n\n/old /new\n",
+			`line looks like a redirect, which belongs in a _redirects file, use a _redirects parser for "/old /new" at line 4`,
+		},
+		{
+			"absolute destination and forced status",
+			"/blog/* https://blog.example.com/:splat 302!\n",
+			`line looks like a redirect, which belongs in a _redirects file, use a _redirects parser for "/blog/* https://blog.example.com/:splat 302!" at line 1`,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			_, err := headers.Parse(strings.NewReader(test.input))
+			assert.EqualError(t, err, test.err)
+			assert.ErrorIs(t, err, headers.ErrLooksLikeRedirect)
+		})
+	}
+
+	// a space within a path is not a redirect
+	file, err := headers.Parse(strings.NewReader("/my page\n  X-Test: true\n"))
+	assert.NoError(t, err)
+	assert.Equal(t, "/my page", (*file)[0].Pattern.Path)
+}
+
+func Test_ParseWithOptions_LeadingSlash(t *testing.T) {
+	input := `/secure/*
+  X-Any: true
+
+secure/page
+  X-Frame-Options: DENY
+`
+	input2, err := url.Parse("https://example.com/secure/page")
+	assert.NoError(t, err)
+
+	// by default the pattern is kept as is, and never matches
+	file, err := headers.Parse(strings.NewReader(input))
+	assert.NoError(t, err)
+	assert.Equal(t, "secure/page", (*file)[1].Pattern.Path)
+	assert.ElementsMatch(t, []string{"X-Any: true"}, file.Match(*input2))
+
+	_, err = headers.ParseWithOptions(strings.NewReader(input), headers.ParseOptions{Strict: true})
+	assert.EqualError(t, err, `path pattern must start with "/" at line 4`)
+
+	for _, opts := range []headers.ParseOptions{{PrependSlash: true}, {PrependSlash: true, Strict: true}} {
+		file, err = headers.ParseWithOptions(strings.NewReader(input), opts)
+		assert.NoError(t, err)
+		assert.Equal(t, "/secure/page", (*file)[1].Pattern.Path)
+		assert.ElementsMatch(t, []string{"X-Any: true", "X-Frame-Options: DENY"}, file.Match(*input2))
+	}
+}
+
+func Test_Rule_Specificity(t *testing.T) {
+	r := strings.NewReader(`https://example.com/*
+  X-Rule: host
+
+/movies/alien
+  X-Rule: exact
+
+/movies/:title
+  X-Rule: placeholder
+
+/movies/*
+  X-Rule: splat
+
+/movies/**
+  X-Rule: greedy
+
+/*
+  X-Rule: root splat
+
+/movies/\*
+  X-Rule: escaped
+`)
+	file, err := headers.Parse(r)
+	assert.NoError(t, err)
+
+	scores := []int{}
+	for _, rule := range *file {
+		scores = append(scores, rule.Specificity())
+	}
+	assert.Equal(t, []int{102, 13, 3, -2, -12, -9, 9}, scores)
+
+	host, exact, placeholder, splat, greedy, root, escaped := scores[0], scores[1], scores[2], scores[3], scores[4], scores[5], scores[6]
+	assert.Greater(t, host, exact)
+	assert.Greater(t, exact, escaped)
+	assert.Greater(t, escaped, placeholder)
+	assert.Greater(t, placeholder, splat)
+	assert.Greater(t, splat, greedy)
+	assert.Greater(t, splat, root)
+}
+
+func Test_Parse_TrailingNewline(t *testing.T) {
+	expected := headers.File{
+		{
+			Pattern: url.URL{Path: "/secure/page"},
+			Headers: []headers.Header{{Name: "X-Frame-Options", Value: "DENY"}},
+			Line:    1,
+		},
+		{
+			Pattern: url.URL{Path: "/static/*"},
+			Headers: []headers.Header{
+				{Name: "Access-Control-Allow-Origin", Value: "*"},
+				{Name: "X-Robots-Tag", Value: "nosnippet"},
+			},
+			Line: 4,
+		},
+	}
+	body := "/secure/page\n  X-Frame-Options: DENY\n\n/static/*\n  Access-Control-Allow-Origin: *\n  X-Robots-Tag: nosnippet"
+
+	tests := []struct {
+		name  string
+		input string
+	}{
+		{"ending mid-header", body},
+		{"trailing newline", body + "\n"},
+		{"trailing blank lines", body + "\n\n\n"},
+		{"trailing whitespace", body + "\n  "},
+		{"trailing comment", body + "\n# end"},
+		{"crlf", strings.ReplaceAll(body, "\n", "\r\n")},
+		{"crlf with trailing newline", strings.ReplaceAll(body, "\n", "\r\n") + "\r\n"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			file, err := headers.Parse(strings.NewReader(test.input))
+			assert.NoError(t, err)
+			assert.Equal(t, expected, *file)
+		})
+	}
+
+	// a final rule with no headers is kept either way
+	for _, input := range []string{"/secure/page", "/secure/page\n"} {
+		file, err := headers.Parse(strings.NewReader(input))
+		assert.NoError(t, err)
+		assert.Equal(t, headers.File{{Pattern: url.URL{Path: "/secure/page"}, Headers: []headers.Header{}, Line: 1}}, *file)
+	}
+}
+
+func Test_File_Match_PartialHostSplat(t *testing.T) {
+	r := strings.NewReader(`https://api-*.example.com/*
+  X-API-Version: :splat
+`)
+	file, err := headers.Parse(r)
+	assert.NoError(t, err)
+
+	tests := []struct {
+		inputURL string
+		expected []string
+	}{
+		{"https://api-v2.example.com/users", []string{"X-API-Version: v2"}},
+		{"https://api-staging.example.com/", []string{"X-API-Version: staging"}},
+		{"https://api-v2.other.com/users", []string{}},
+		{"https://api-v2.example.com.evil.com/users", []string{}},
+		{"https://api-v2.staging.example.com/users", []string{}},
+		{"https://www.api-v2.example.com/users", []string{}},
+		{"https://api.example.com/users", []string{}},
+	}
+	for _, test := range tests {
+		t.Run(test.inputURL, func(t *testing.T) {
+			input, err := url.Parse(test.inputURL)
+			assert.NoError(t, err)
+			assert.ElementsMatch(t, test.expected, file.Match(*input))
+		})
+	}
+}
+
+func Test_File_MatchChain(t *testing.T) {
+	r := strings.NewReader(`/download/*
+  Content-Disposition: attachment
+
+/static/*
+  Cache-Control: max-age=3600
+
+/download/:file
+  X-File: :file
+
+https://:subdomain.example.com/*
+  X-Subdomain: :subdomain
+
+/download/a.zip
+  X-Exact: true
+`)
+	file, err := headers.Parse(r)
+	assert.NoError(t, err)
+
+	input, err := url.Parse("https://downloads.example.com/download/a.zip")
+	assert.NoError(t, err)
+	assert.Equal(t, []int{0, 2, 3, 4}, file.MatchChain(*input))
+
+	input, err = url.Parse("https://example.com/download/b.zip")
+	assert.NoError(t, err)
+	assert.Equal(t, []int{0, 2}, file.MatchChain(*input))
+
+	input, err = url.Parse("https://example.com/about")
+	assert.NoError(t, err)
+	assert.Empty(t, file.MatchChain(*input))
+}
+
+func Test_ParseWithOptions_Unquote(t *testing.T) {
+	input := `/*
+  X-Padded: "  bar  "
+  X-Escaped: "tab\there"
+  X-Plain: bar
+  X-Embedded: You are watching "bar"
+  Clear-Site-Data: "cache", "cookies"
+  X-Empty: ""
+  ! X-Detach: "  bar  "
+`
+	tests := []struct {
+		name     string
+		opts     headers.ParseOptions
+		expected []headers.Header
+	}{
+		{
+			"unquote",
+			headers.ParseOptions{Unquote: true},
+			[]headers.Header{
+				{Name: "X-Padded", Value: "  bar  "},
+				{Name: "X-Escaped", Value: "tab\there"},
+				{Name: "X-Plain", Value: "bar"},
+				{Name: "X-Embedded", Value: `You are watching "bar"`},
+				{Name: "Clear-Site-Data", Value: `"cache", "cookies"`},
+				{Name: "X-Empty", Value: ""},
+				{Name: "X-Detach", Value: "  bar  ", Detach: true},
+			},
+		},
+		{
+			"default",
+			headers.ParseOptions{},
+			[]headers.Header{
+				{Name: "X-Padded", Value: `"  bar  "`},
+				{Name: "X-Escaped", Value: `"tab\there"`},
+				{Name: "X-Plain", Value: "bar"},
+				{Name: "X-Embedded", Value: `You are watching "bar"`},
+				{Name: "Clear-Site-Data", Value: `"cache", "cookies"`},
+				{Name: "X-Empty", Value: `""`},
+				{Name: "X-Detach", Value: `"  bar  "`, Detach: true},
+			},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			file, err := headers.ParseWithOptions(strings.NewReader(input), test.opts)
+			assert.NoError(t, err)
+			assert.Equal(t, test.expected, (*file)[0].Headers)
+		})
+	}
+}
+
+func Test_File_MatchWith(t *testing.T) {
+	r := strings.NewReader(`/docs/*
+  X-Section: :splat
+
+/docs/api
+  X-API: true
+
+/blog/*
+  X-Blog: true
+`)
+	file, err := headers.Parse(r)
+	assert.NoError(t, err)
+
+	// a splat matching any number of segments, by simple prefix comparison
+	prefix := func(rule headers.Rule, in url.URL) (bool, map[string]string) {
+		if before, ok := strings.CutSuffix(rule.Pattern.Path, "*"); ok {
+			if rest, ok := strings.CutPrefix(in.Path, before); ok {
+				return true, map[string]string{":splat": rest}
+			}
+			return false, nil
+		}
+		return rule.Pattern.Path == in.Path, nil
+	}
+
+	input, err := url.Parse("https://example.com/docs/guides/setup")
+	assert.NoError(t, err)
+	assert.ElementsMatch(t, []string{"X-Section: guides/setup"}, file.MatchWith(*input, prefix))
+	assert.Empty(t, file.Match(*input))
+
+	input, err = url.Parse("https://example.com/docs/api")
+	assert.NoError(t, err)
+	assert.ElementsMatch(t, []string{"X-Section: api", "X-API: true"}, file.MatchWith(*input, prefix))
+
+	never := func(headers.Rule, url.URL) (bool, map[string]string) { return false, nil }
+	assert.Empty(t, file.MatchWith(*input, never))
+}
+
+func Test_File_MatchWith_PrefixedPlaceholders(t *testing.T) {
+	file, err := headers.Parse(strings.NewReader("/*\n  X: :ab :a\n"))
+	assert.NoError(t, err)
+
+	bindings := func(headers.Rule, url.URL) (bool, map[string]string) {
+		return true, map[string]string{":a": "1", ":ab": "2"}
+	}
+	// ":ab" is substituted whole, whatever order the bindings are in
+	for i := 0; i < 20; i++ {
+		assert.Equal(t, []string{"X: 2 1"}, file.MatchWith(url.URL{Path: "/"}, bindings))
+	}
+}
+
+func Test_FlattenWithPolicy(t *testing.T) {
+	stack := []headers.Header{
+		{Name: "Cache-Control", Value: "public"},
+		{Name: "Set-Cookie", Value: "a=1"},
+		{Name: "X-Robots-Tag", Value: "noindex"},
+		{Name: "cache-control", Value: "max-age=60"},
+		{Name: "Set-Cookie", Value: "b=2"},
+		{Name: "X-Robots-Tag", Value: "nosnippet"},
+	}
+	policy := headers.JoinPolicy{
+		"cache-control": ", ",
+		"Set-Cookie":    headers.JoinRepeat,
+	}
+
+	assert.Equal(t, []string{
+		"Cache-Control: public, max-age=60",
+		"Set-Cookie: a=1",
+		"Set-Cookie: b=2",
+		"X-Robots-Tag: noindex,nosnippet",
+	}, headers.FlattenWithPolicy(stack, policy))
+
+	assert.Equal(t, headers.Flatten(stack), headers.FlattenWithPolicy(stack, nil))
+}
+
+func Test_File_MatchWithOptions_JoinPolicy(t *testing.T) {
+	r := strings.NewReader(`/account/*
+  Cache-Control: private
+  Set-Cookie: session=1; Secure
+
+/account/settings
+  Cache-Control: no-store
+  Set-Cookie: theme=dark
+`)
+	file, err := headers.Parse(r)
+	assert.NoError(t, err)
+
+	input, err := url.Parse("https://example.com/account/settings")
+	assert.NoError(t, err)
+
+	opts := headers.MatchOptions{JoinPolicy: headers.JoinPolicy{
+		"Cache-Control": ", ",
+		"Set-Cookie":    headers.JoinRepeat,
+	}}
+	assert.Equal(t, []string{
+		"Cache-Control: private, no-store",
+		"Set-Cookie: session=1; Secure",
+		"Set-Cookie: theme=dark",
+	}, file.MatchWithOptions(*input, opts))
+
+	matcher := headers.NewMatcher(*file, headers.WithMatchOptions(opts))
+	assert.Equal(t, file.MatchWithOptions(*input, opts), matcher.Match(*input))
+}
+
+func Test_File_Match_SetCookie(t *testing.T) {
+	r := strings.NewReader(`/*
+  Set-Cookie: consent=1; Expires=Wed, 21 Oct 2026 07:28:00 GMT
+
+/account
+  set-cookie: session=abc; Secure
+`)
+	file, err := headers.Parse(r)
+	assert.NoError(t, err)
+
+	input, err := url.Parse("https://example.com/account")
+	assert.NoError(t, err)
+
+	assert.Equal(t, []string{
+		"Set-Cookie: consent=1; Expires=Wed, 21 Oct 2026 07:28:00 GMT",
+		"Set-Cookie: session=abc; Secure",
+	}, file.Match(*input))
+
+	// unless a policy says otherwise
+	assert.Equal(t, []string{
+		"Set-Cookie: consent=1; Expires=Wed, 21 Oct 2026 07:28:00 GMT,session=abc; Secure",
+	}, file.MatchWithOptions(*input, headers.MatchOptions{JoinPolicy: headers.JoinPolicy{"Set-Cookie": ","}}))
+}
+
+// A host pattern matches its path as well as its host, unless matched with
+// HostWideOnTrailingSlash, where a pattern for only "/" applies host-wide.
+func Test_File_Match_HostWide(t *testing.T) {
+	tests := []struct {
+		pattern  string
+		hostWide bool
+		matched  []string
+	}{
+		{"https://example.com/", false, []string{"/"}},
+		{"https://example.com/", true, []string{"/", "/about", "/static/styles.css"}},
+		{"https://example.com/*", false, []string{"/", "/about", "/static/styles.css"}},
+		{"https://example.com/*", true, []string{"/", "/about", "/static/styles.css"}},
+		{"https://example.com/about", false, []string{"/about"}},
+		{"https://example.com/about", true, []string{"/about"}},
+		{"https://example.com/static/:file", false, []string{"/static/styles.css"}},
+	}
+
+	for _, test := range tests {
+		t.Run(fmt.Sprintf("%s %t", test.pattern, test.hostWide), func(t *testing.T) {
+			file, err := headers.Parse(strings.NewReader(test.pattern + "\n  X-Host: true\n"))
+			assert.NoError(t, err)
+			opts := headers.MatchOptions{HostWideOnTrailingSlash: test.hostWide}
+
+			for _, path := range []string{"/", "/about", "/static/styles.css"} {
+				input, err := url.Parse("https://example.com" + path)
+				assert.NoError(t, err)
+				if slices.Contains(test.matched, path) {
+					assert.Equal(t, []string{"X-Host: true"}, file.MatchWithOptions(*input, opts), path)
+				} else {
+					assert.Empty(t, file.MatchWithOptions(*input, opts), path)
+				}
+			}
+
+			input, err := url.Parse("https://example.org/")
+			assert.NoError(t, err)
+			assert.Empty(t, file.MatchWithOptions(*input, opts))
+		})
+	}
+}
+
+func Test_ParseWithOptions_ExpandEnv(t *testing.T) {
+	input := `/${BUILD_ID}/*
+  X-Build: ${BUILD_ID}
+  X-Release: ${RELEASE}-${BUILD_ID}
+  X-Price: $5
+`
+	lookup := func(name string) (string, bool) {
+		if name == "BUILD_ID" {
+			return "1234", true
+		}
+		return "", false
+	}
+
+	file, err := headers.ParseWithOptions(strings.NewReader(input), headers.ParseOptions{ExpandEnv: true, LookupEnv: lookup})
+	assert.NoError(t, err)
+	// patterns are never expanded
+	assert.Equal(t, "/${BUILD_ID}/*", (*file)[0].Pattern.Path)
+	assert.Equal(t, []headers.Header{
+		{Name: "X-Build", Value: "1234"},
+		{Name: "X-Release", Value: "-1234"},
+		{Name: "X-Price", Value: "$5"},
+	}, (*file)[0].Headers)
+
+	_, err = headers.ParseWithOptions(strings.NewReader(input), headers.ParseOptions{ExpandEnv: true, LookupEnv: lookup, Strict: true})
+	assert.EqualError(t, err, `undefined variable "RELEASE" at line 3`)
+
+	// without ExpandEnv, values are kept as written
+	file, err = headers.ParseWithOptions(strings.NewReader(input), headers.ParseOptions{LookupEnv: lookup})
+	assert.NoError(t, err)
+	assert.Equal(t, "${BUILD_ID}", (*file)[0].Headers[0].Value)
+
+	t.Setenv("CF_HEADERS_TEST_BUILD_ID", "5678")
+	file, err = headers.ParseWithOptions(strings.NewReader("/*\n  X-Build: ${CF_HEADERS_TEST_BUILD_ID}\n"), headers.ParseOptions{ExpandEnv: true})
+	assert.NoError(t, err)
+	assert.Equal(t, "5678", (*file)[0].Headers[0].Value)
+}
+
+func Test_File_MatchWithOptions_ASCIIHost(t *testing.T) {
+	r := strings.NewReader(`https://bücher.example/*
+  X-Unicode-Pattern: true
+
+https://xn--mnchen-3ya.example/*
+  X-Punycode-Pattern: true
+
+https://:shop.example/*
+  X-Shop: :shop
+`)
+	file, err := headers.Parse(r)
+	assert.NoError(t, err)
+
+	tests := []struct {
+		name     string
+		input    url.URL
+		expected []string
+		ascii    []string
+	}{
+		{
+			"unicode pattern, unicode request",
+			url.URL{Scheme: "https", Host: "bücher.example", Path: "/"},
+			[]string{"X-Unicode-Pattern: true", "X-Shop: bücher"},
+			[]string{"X-Unicode-Pattern: true", "X-Shop: xn--bcher-kva"},
+		},
+		{
+			"unicode pattern, punycode request",
+			url.URL{Scheme: "https", Host: "xn--bcher-kva.example", Path: "/"},
+			[]string{"X-Shop: xn--bcher-kva"},
+			[]string{"X-Unicode-Pattern: true", "X-Shop: xn--bcher-kva"},
+		},
+		{
+			"punycode pattern, unicode request",
+			url.URL{Scheme: "https", Host: "MÜNCHEN.example", Path: "/"},
+			[]string{"X-Shop: MÜNCHEN"},
+			[]string{"X-Punycode-Pattern: true", "X-Shop: xn--mnchen-3ya"},
+		},
+		{
+			"punycode pattern, punycode request",
+			url.URL{Scheme: "https", Host: "xn--mnchen-3ya.example", Path: "/"},
+			[]string{"X-Punycode-Pattern: true", "X-Shop: xn--mnchen-3ya"},
+			[]string{"X-Punycode-Pattern: true", "X-Shop: xn--mnchen-3ya"},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			assert.Equal(t, test.expected, file.Match(test.input))
+			assert.Equal(t, test.ascii, file.MatchWithOptions(test.input, headers.MatchOptions{ASCIIHost: true}))
+		})
+	}
+}
+
+func Test_ParseWithOptions_ParseGroups(t *testing.T) {
+	input := `/*
+  X-Ungrouped: true
+
+# @group security
+/*
+  X-Frame-Options: DENY
+
+# Strict transport for everything
+/admin/*
+  Strict-Transport-Security: max-age=31536000
+
+#@group   cache control
+/static/*
+  Cache-Control: max-age=3600
+
+# @group
+/about
+  X-About: true
+`
+	file, err := headers.ParseWithOptions(strings.NewReader(input), headers.ParseOptions{ParseGroups: true})
+	assert.NoError(t, err)
+
+	var groups []string
+	for _, rule := range *file {
+		groups = append(groups, rule.Group)
+		assert.Nil(t, rule.Comments)
+	}
+	assert.Equal(t, []string{"", "security", "security", "cache control", ""}, groups)
+
+	file, err = headers.ParseWithOptions(strings.NewReader(input), headers.ParseOptions{ParseGroups: true, KeepComments: true})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"# @group security"}, (*file)[1].Comments)
+	assert.Equal(t, "security", (*file)[1].Group)
+
+	// annotations are ordinary comments by default
+	file, err = headers.Parse(strings.NewReader(input))
+	assert.NoError(t, err)
+	for _, rule := range *file {
+		assert.Empty(t, rule.Group)
+	}
+}
+
+func Test_File_FilterByGroup(t *testing.T) {
+	input := `# @group security
+/*
+  X-Frame-Options: DENY
+
+# @group cache
+/static/*
+  Cache-Control: max-age=3600
+
+# @group security
+/admin/*
+  X-Robots-Tag: noindex
+`
+	file, err := headers.ParseWithOptions(strings.NewReader(input), headers.ParseOptions{ParseGroups: true})
+	assert.NoError(t, err)
+
+	assert.Equal(t, headers.File{(*file)[0], (*file)[2]}, file.FilterByGroup("security"))
+	assert.Equal(t, headers.File{(*file)[1]}, file.FilterByGroup("cache"))
+	assert.Equal(t, headers.File{}, file.FilterByGroup("Security"))
+}
+
+func Test_File_Match_CaptureInjection(t *testing.T) {
+	r := strings.NewReader(`/echo/:id
+  X-Echo: :id
+
+/files/*
+  X-File: :splat
+`)
+	file, err := headers.Parse(r)
+	assert.NoError(t, err)
+
+	tests := []struct {
+		name     string
+		input    string
+		expected []string
+	}{
+		{"placeholder", "https://example.com/echo/1%0d%0aSet-Cookie:%20session=evil", []string{"X-Echo: 1Set-Cookie: session=evil"}},
+		{"splat", "https://example.com/files/a%0Ab%0Dc%00d", []string{"X-File: abcd"}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			input, err := url.Parse(test.input)
+			assert.NoError(t, err)
+			assert.True(t, strings.ContainsAny(input.Path, "\r\n"))
+
+			assert.Equal(t, test.expected, file.Match(*input))
+			for _, header := range file.MatchResolved(*input) {
+				assert.NotContains(t, header.Value, "\r")
+				assert.NotContains(t, header.Value, "\n")
+				assert.NotContains(t, header.Value, "\x00")
+			}
+		})
+	}
+}
+
+func Test_File_MatchWithOptions_SortOutput(t *testing.T) {
+	r := strings.NewReader(`/secure/*
+  X-Frame-Options: DENY
+  Set-Cookie: b=2
+  cache-control: no-store
+
+/secure/page
+  Access-Control-Allow-Origin: *
+  Set-Cookie: a=1
+  X-Content-Type-Options: nosniff
+`)
+	file, err := headers.Parse(r)
+	assert.NoError(t, err)
+
+	input, err := url.Parse("https://example.com/secure/page")
+	assert.NoError(t, err)
+
+	// source order by default
+	assert.Equal(t, []string{
+		"X-Frame-Options: DENY",
+		"Set-Cookie: b=2",
+		"Set-Cookie: a=1",
+		"cache-control: no-store",
+		"Access-Control-Allow-Origin: *",
+		"X-Content-Type-Options: nosniff",
+	}, file.Match(*input))
+
+	sorted := []string{
+		"Access-Control-Allow-Origin: *",
+		"cache-control: no-store",
+		"Set-Cookie: b=2",
+		"Set-Cookie: a=1",
+		"X-Content-Type-Options: nosniff",
+		"X-Frame-Options: DENY",
+	}
+	opts := headers.MatchOptions{SortOutput: true}
+	assert.Equal(t, sorted, file.MatchWithOptions(*input, opts))
+	assert.Equal(t, sorted, headers.NewMatcher(*file, headers.WithMatchOptions(opts)).Match(*input))
+}
+
+func Test_ParseWithOptions_AllowEquals(t *testing.T) {
+	input := `/*
+  X-Foo = bar
+  X-Url = https://example.com/?a=b
+  X-Query: a=b
+  ! X-Bar = baz
+`
+	_, err := headers.Parse(strings.NewReader(input))
+	assert.EqualError(t, err, `invalid header: "X-Foo = bar" at line 2`)
+
+	file, err := headers.ParseWithOptions(strings.NewReader(input), headers.ParseOptions{AllowEquals: true})
+	assert.NoError(t, err)
+	assert.Equal(t, []headers.Header{
+		{Name: "X-Foo", Value: "bar"},
+		{Name: "X-Url", Value: "https://example.com/?a=b"},
+		{Name: "X-Query", Value: "a=b"},
+		{Name: "X-Bar", Value: "baz", Detach: true},
+	}, (*file)[0].Headers)
+
+	// strict mode still requires a colon
+	_, err = headers.ParseWithOptions(strings.NewReader(input), headers.ParseOptions{AllowEquals: true, Strict: true})
+	assert.EqualError(t, err, `invalid header: "X-Foo = bar" at line 2`)
+}
+
+// The sample strings of RFC 3492 section 7.1, which ASCIIHost must encode as
+// their Punycode form. Basic code points are lower cased along with the rest
+// of the label, which leaves the encoded deltas unchanged.
+func Test_File_MatchWithOptions_ASCIIHost_RFC3492(t *testing.T) {
+	tests := []struct {
+		name     string
+		label    string
+		punycode string
+	}{
+		{"(A) Arabic (Egyptian)", "ليهمابتكلموشعربي؟", "egbpdaj6bu4bxfgehfvwxn"},
+		{"(B) Chinese (simplified)", "他们为什么不说中文", "ihqwcrb4cv8a8dqg056pqjye"},
+		{"(C) Chinese (traditional)", "他們爲什麽不說中文", "ihqwctvzc91f659drss3x8bo0yb"},
+		{"(D) Czech", "Pročprostěnemluvíčesky", "Proprostnemluvesky-uyb24dma41a"},
+		{"(E) Hebrew", "למההםפשוטלאמדבריםעברית", "4dbcagdahymbxekheh6e0a7fei0b"},
+		{"(F) Hindi (Devanagari)", "यहलोगहिन्दीक्योंनहींबोलसकतेहैं", "i1baa7eci9glrd9b2ae1bj0hfcgg6iyaf8o0a1dig0cd"},
+		{"(G) Japanese (kanji and hiragana)", "なぜみんな日本語を話してくれないのか", "n8jok5ay5dzabd5bym9f0cm5685rrjetr6pdxa"},
+		{"(H) Korean (Hangul syllables)", "세계의모든사람들이한국어를이해한다면얼마나좋을까", "989aomsvi5e83db1d2a355cv1e0vak1dwrv93d5xbh15a0dt30a5jpsd879ccm6fea98c"},
+		{"(I) Russian (Cyrillic)", "почемужеонинеговорятпорусски", "b1abfaaepdrnnbgefbadotcwatmq2g4l"},
+		{"(J) Spanish", "PorquénopuedensimplementehablarenEspañol", "PorqunopuedensimplementehablarenEspaol-fmd56a"},
+		{"(K) Vietnamese", "TạisaohọkhôngthểchỉnóitiếngViệt", "TisaohkhngthchnitingVit-kjcr8268qyxafd2f1b9g"},
+		{"(L) 3<nen>B<gumi><kinpachi><sensei>", "3年B組金八先生", "3B-ww4c5e180e575a65lsy2b"},
+		{"(M) <amuro><namie>-with-SUPER-MONKEYS", "安室奈美恵-with-SUPER-MONKEYS", "-with-SUPER-MONKEYS-pc58ag80a8qai00g7n9n"},
+		{"(N) Hello-Another-Way-<sorezore><no><basho>", "Hello-Another-Way-それぞれの場所", "Hello-Another-Way--fc4qua05auwb3674vfr0b"},
+		{"(O) <hitotsu><yane><no><shita>2", "ひとつ屋根の下2", "2-u9tlzr9756bt3uc0v"},
+		{"(P) Maji<de>Koi<suru>5<byou><mae>", "MajiでKoiする5秒前", "MajiKoi5-783gue6qz075azm5e"},
+		{"(Q) <pafii>de<runba>", "パフィーdeルンバ", "de-jg4avhby1noc0d"},
+		{"(R) <sono><supiido><de>", "そのスピードで", "d9juau41awczczp"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			file := headers.File{{
+				Pattern: url.URL{Scheme: "https", Host: test.label + ".example", Path: "/"},
+				Headers: []headers.Header{{Name: "X-Sample", Value: "true"}},
+			}}
+			input := url.URL{Scheme: "https", Host: "xn--" + strings.ToLower(test.punycode) + ".example", Path: "/"}
+			assert.Equal(t, []string{"X-Sample: true"}, file.MatchWithOptions(input, headers.MatchOptions{ASCIIHost: true}))
+			assert.Empty(t, file.Match(input))
+		})
+	}
+
+	// (S) is all basic code points, so is kept as it is rather than encoded
+	file := headers.File{{
+		Pattern: url.URL{Scheme: "https", Host: "-> $1.00 <-.example", Path: "/"},
+		Headers: []headers.Header{{Name: "X-Sample", Value: "true"}},
+	}}
+	input := url.URL{Scheme: "https", Host: "-> $1.00 <-.example", Path: "/"}
+	assert.Equal(t, []string{"X-Sample: true"}, file.MatchWithOptions(input, headers.MatchOptions{ASCIIHost: true}))
+}
@@ -0,0 +1,79 @@
+package headers
+
+import (
+	"net/http"
+	"net/textproto"
+	"net/url"
+	"slices"
+	"strings"
+)
+
+// MatchRequest matches all the rules against the URL of the request, returning
+// the headers to apply. Rules restricted to specific methods only apply to
+// requests using one of those methods.
+func (h File) MatchRequest(r *http.Request) []string {
+	in := *r.URL
+	if in.Host == "" {
+		in.Host = r.Host
+	}
+
+	rules := File{}
+	for _, rule := range h {
+		if rule.allowsMethod(r.Method) {
+			rules = append(rules, rule)
+		}
+	}
+
+	return rules.Match(in)
+}
+
+// MatchMIMEHeader matches all the rules against the input URL, returning the
+// headers to apply with canonical keys. Each value set for a header is kept as
+// a separate entry, rather than joined with a comma.
+func (h File) MatchMIMEHeader(in url.URL) textproto.MIMEHeader {
+	out := textproto.MIMEHeader{}
+	for _, resolved := range resolve(h.headerStack(in, MatchOptions{})) {
+		for _, value := range resolved.values {
+			out.Add(resolved.name, value)
+		}
+	}
+	return out
+}
+
+// ApplyTo matches all the rules against the input URL and applies the result
+// to h. Headers named by a detach are removed from h, whether or not a rule
+// set them, and then each matched header replaces any values h has for it.
+func (h File) ApplyTo(header http.Header, in url.URL) {
+	headerStack := h.headerStack(in, MatchOptions{})
+	for _, detach := range headerStack {
+		if !detach.Detach {
+			continue
+		}
+		for name, values := range header {
+			if !detaches(detach.Name, name) {
+				continue
+			}
+			if detach.Value != "" && !strings.Contains(detach.Name, "*") {
+				values = slices.DeleteFunc(values, func(value string) bool {
+					return value == detach.Value
+				})
+				if len(values) > 0 {
+					header[name] = values
+					continue
+				}
+			}
+			delete(header, name)
+		}
+	}
+
+	for _, resolved := range resolve(headerStack) {
+		header.Del(resolved.name)
+		for _, value := range resolved.values {
+			header.Add(resolved.name, value)
+		}
+	}
+}
+
+func (r Rule) allowsMethod(method string) bool {
+	return len(r.Methods) == 0 || slices.Contains(r.Methods, strings.ToUpper(method))
+}
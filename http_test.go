@@ -0,0 +1,118 @@
+package headers_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/textproto"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	headers "github.com/jmhobbs/cloudflare-headers-file"
+)
+
+func Test_ParseWithOptions_AllowMethods(t *testing.T) {
+	r := strings.NewReader(`GET,HEAD /api/*
+  Cache-Control: max-age=60
+
+POST /api/*
+  Cache-Control: no-store
+
+/api/*
+  X-API: true
+`)
+	file, err := headers.ParseWithOptions(r, headers.ParseOptions{AllowMethods: true})
+	assert.NoError(t, err)
+
+	assert.Equal(t, []string{"GET", "HEAD"}, (*file)[0].Methods)
+	assert.Equal(t, url.URL{Path: "/api/*"}, (*file)[0].Pattern)
+	assert.Equal(t, []string{"POST"}, (*file)[1].Methods)
+	assert.Empty(t, (*file)[2].Methods)
+
+	tests := []struct {
+		method   string
+		expected []string
+	}{
+		{"GET", []string{"Cache-Control: max-age=60", "X-API: true"}},
+		{"HEAD", []string{"Cache-Control: max-age=60", "X-API: true"}},
+		{"POST", []string{"Cache-Control: no-store", "X-API: true"}},
+		{"DELETE", []string{"X-API: true"}},
+	}
+	for _, test := range tests {
+		t.Run(test.method, func(t *testing.T) {
+			req := httptest.NewRequest(test.method, "https://example.com/api/users", nil)
+			assert.ElementsMatch(t, test.expected, file.MatchRequest(req))
+		})
+	}
+}
+
+func Test_Parse_MethodsNotAllowed(t *testing.T) {
+	r := strings.NewReader(`GET /api/*
+  Cache-Control: max-age=60
+`)
+	file, err := headers.Parse(r)
+	assert.NoError(t, err)
+	assert.Empty(t, (*file)[0].Methods)
+	assert.Equal(t, "GET /api/*", (*file)[0].Pattern.Path)
+}
+
+func Test_File_MatchMIMEHeader(t *testing.T) {
+	r := strings.NewReader(`/static/*
+  access-control-allow-origin: *
+  x-robots-tag: nosnippet
+
+https://myproject.pages.dev/*
+  X-Robots-Tag: noindex
+`)
+	file, err := headers.Parse(r)
+	assert.NoError(t, err)
+
+	input, err := url.Parse("https://myproject.pages.dev/static/styles.css")
+	assert.NoError(t, err)
+
+	assert.Equal(t, textproto.MIMEHeader{
+		"Access-Control-Allow-Origin": {"*"},
+		"X-Robots-Tag":                {"nosnippet", "noindex"},
+	}, file.MatchMIMEHeader(*input))
+}
+
+func Test_File_ApplyTo(t *testing.T) {
+	r := strings.NewReader(`/secure/*
+  X-Frame-Options: DENY
+  Cache-Control: no-store
+  ! Server
+  ! X-Debug-*
+  ! Vary: Cookie
+`)
+	file, err := headers.Parse(r)
+	assert.NoError(t, err)
+
+	header := http.Header{}
+	header.Set("Cache-Control", "max-age=3600")
+	header.Set("Server", "origin")
+	header.Set("X-Debug-Trace", "abc")
+	header.Set("X-Debug-Time", "12ms")
+	header.Add("Vary", "Accept")
+	header.Add("Vary", "Cookie")
+	header.Set("Content-Type", "text/html")
+
+	input, err := url.Parse("https://example.com/secure/page")
+	assert.NoError(t, err)
+	file.ApplyTo(header, *input)
+
+	assert.Equal(t, http.Header{
+		"Cache-Control":   {"no-store"},
+		"Content-Type":    {"text/html"},
+		"Vary":            {"Accept"},
+		"X-Frame-Options": {"DENY"},
+	}, header)
+
+	// nothing is changed for URLs no rule matches
+	header = http.Header{"Server": {"origin"}}
+	input, err = url.Parse("https://example.com/public")
+	assert.NoError(t, err)
+	file.ApplyTo(header, *input)
+	assert.Equal(t, http.Header{"Server": {"origin"}}, header)
+}
@@ -0,0 +1,53 @@
+package headers
+
+import (
+	"encoding/json"
+)
+
+type jsonRule struct {
+	Pattern  string   `json:"pattern"`
+	Methods  []string `json:"methods,omitempty"`
+	Headers  []Header `json:"headers"`
+	Comments []string `json:"comments,omitempty"`
+	Group    string   `json:"group,omitempty"`
+	Line     int      `json:"line,omitempty"`
+}
+
+// MarshalJSON encodes the rule with its pattern in _headers file form.
+func (r Rule) MarshalJSON() ([]byte, error) {
+	return json.Marshal(jsonRule{
+		Pattern:  formatPattern(r.Pattern),
+		Methods:  r.Methods,
+		Headers:  r.Headers,
+		Comments: r.Comments,
+		Group:    r.Group,
+		Line:     r.Line,
+	})
+}
+
+// UnmarshalJSON decodes a rule encoded by MarshalJSON, parsing and validating
+// its pattern as Parse would.
+func (r *Rule) UnmarshalJSON(data []byte) error {
+	var in jsonRule
+	if err := json.Unmarshal(data, &in); err != nil {
+		return err
+	}
+
+	pattern, err := parsePattern(in.Pattern, ParseOptions{})
+	if err != nil {
+		return err
+	}
+	if in.Headers == nil {
+		in.Headers = []Header{}
+	}
+
+	*r = Rule{
+		Pattern:  *pattern,
+		Methods:  in.Methods,
+		Headers:  in.Headers,
+		Comments: in.Comments,
+		Group:    in.Group,
+		Line:     in.Line,
+	}
+	return nil
+}
@@ -0,0 +1,74 @@
+package headers_test
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	headers "github.com/jmhobbs/cloudflare-headers-file"
+)
+
+func Test_File_JSON(t *testing.T) {
+	file, err := headers.Parse(strings.NewReader(`/secure/page
+  X-Frame-Options: DENY
+  ! X-Robots-Tag
+`))
+	assert.NoError(t, err)
+
+	data, err := json.Marshal(file)
+	assert.NoError(t, err)
+	assert.JSONEq(t, `[{
+		"pattern": "/secure/page",
+		"headers": [
+			{"name": "X-Frame-Options", "value": "DENY"},
+			{"name": "X-Robots-Tag", "detach": true}
+		],
+		"line": 1
+	}]`, string(data))
+
+	var decoded headers.File
+	assert.NoError(t, json.Unmarshal(data, &decoded))
+	assert.Equal(t, *file, decoded)
+
+	assert.Error(t, json.Unmarshal([]byte(`[{"pattern": "ftp://example.com/*"}]`), &decoded))
+}
+
+func Test_File_JSON_Comments(t *testing.T) {
+	original := `# Security headers
+# for every page
+/*
+  X-Frame-Options: DENY
+  ! X-Robots-Tag
+
+# Assets
+https://myproject.pages.dev/static/:file
+  Cache-Control: max-age=3600
+`
+	file, err := headers.ParseWithOptions(strings.NewReader(original), headers.ParseOptions{KeepComments: true})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"# Security headers", "# for every page"}, (*file)[0].Comments)
+	assert.Equal(t, []string{"# Assets"}, (*file)[1].Comments)
+
+	data, err := json.Marshal(file)
+	assert.NoError(t, err)
+	assert.Contains(t, string(data), `"comments":["# Assets"]`)
+
+	var decoded headers.File
+	assert.NoError(t, json.Unmarshal(data, &decoded))
+	assert.Equal(t, original, decoded.String())
+}
+
+func Test_File_JSON_Group(t *testing.T) {
+	file, err := headers.ParseWithOptions(strings.NewReader("# @group security\n/*\n  X-Frame-Options: DENY\n"), headers.ParseOptions{ParseGroups: true})
+	assert.NoError(t, err)
+
+	data, err := json.Marshal(file)
+	assert.NoError(t, err)
+	assert.Contains(t, string(data), `"group":"security"`)
+
+	var decoded headers.File
+	assert.NoError(t, json.Unmarshal(data, &decoded))
+	assert.Equal(t, *file, decoded)
+}
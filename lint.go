@@ -0,0 +1,373 @@
+package headers
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"slices"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// Issue is a likely mistake found while linting a File.
+type Issue struct {
+	// Line is the line number of the rule the issue was found in.
+	Line    int
+	Message string
+}
+
+// Lint checks every rule for likely mistakes which parsing alone does not
+// reject, returning the issues found in file order.
+func (f File) Lint() []Issue {
+	issues := []Issue{}
+	for _, finding := range f.Report().Findings {
+		issues = append(issues, Issue{Line: finding.Line, Message: finding.Message})
+	}
+	return issues
+}
+
+// Severity is how serious a Finding is.
+type Severity string
+
+const (
+	// SeverityError is for a rule which can never work as intended, or which
+	// Cloudflare may reject.
+	SeverityError Severity = "error"
+	// SeverityWarning is for a rule which works, but is likely a mistake.
+	SeverityWarning Severity = "warning"
+)
+
+// Report is the result of linting a File, for tools to render as text or JSON.
+type Report struct {
+	Findings []Finding `json:"findings"`
+}
+
+// Finding is an issue found by Report, with a severity and a stable code
+// identifying the check which found it.
+type Finding struct {
+	Line     int      `json:"line"`
+	Severity Severity `json:"severity"`
+	Code     string   `json:"code"`
+	Message  string   `json:"message"`
+}
+
+// HasErrors reports whether any finding has SeverityError.
+func (r Report) HasErrors() bool {
+	return slices.ContainsFunc(r.Findings, func(finding Finding) bool {
+		return finding.Severity == SeverityError
+	})
+}
+
+// LintOptions enable lint checks which are not run by default.
+type LintOptions struct {
+	// CSP checks the syntax of Content-Security-Policy values, warning of
+	// unknown directives, unbalanced quotes, and commas, which separate
+	// policies rather than sources.
+	CSP bool
+}
+
+// Report runs every default lint check against every rule, returning the
+// findings in file order.
+func (f File) Report() Report {
+	return f.ReportWithOptions(LintOptions{})
+}
+
+// ReportWithOptions runs the default lint checks, and those enabled by the
+// options, against every rule, returning the findings in file order.
+func (f File) ReportWithOptions(opts LintOptions) Report {
+	report := Report{Findings: []Finding{}}
+	for _, rule := range f {
+		for _, check := range lintChecks {
+			if check.enabled != nil && !check.enabled(opts) {
+				continue
+			}
+			for _, issue := range check.check(rule) {
+				report.Findings = append(report.Findings, Finding{
+					Line:     issue.Line,
+					Severity: check.severity,
+					Code:     check.code,
+					Message:  issue.Message,
+				})
+			}
+		}
+	}
+	return report
+}
+
+// ValidateAllowlist returns an error listing every header set by a rule which
+// is not in the allowed list of names, compared case-insensitively. Detaches
+// are not checked.
+func (f File) ValidateAllowlist(allowed []string) error {
+	errs := []error{}
+	for _, rule := range f {
+		for _, header := range rule.Headers {
+			if header.Detach {
+				continue
+			}
+			if !slices.ContainsFunc(allowed, func(name string) bool { return strings.EqualFold(name, header.Name) }) {
+				errs = append(errs, fmt.Errorf("header %q not in allowlist in rule at line %d", header.Name, rule.Line))
+			}
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// lintCheck is a lint check, and the code and severity of its findings. A
+// check with enabled set only runs when it reports true for the options.
+type lintCheck struct {
+	code     string
+	severity Severity
+	check    func(Rule) []Issue
+	enabled  func(LintOptions) bool
+}
+
+var lintChecks = []lintCheck{
+	{"invalid-placeholder", SeverityError, lintInvalidPlaceholder, nil},
+	{"byte-order-mark", SeverityWarning, lintByteOrderMark, nil},
+	{"value-characters", SeverityWarning, lintValueCharacters, nil},
+	{"no-headers", SeverityWarning, lintNoHeaders, nil},
+	{"pattern-characters", SeverityError, lintPatternCharacters, nil},
+	{"csp-syntax", SeverityWarning, lintCSPSyntax, func(opts LintOptions) bool { return opts.CSP }},
+}
+
+// invalidPlaceholderMatcher finds a colon starting a host label or path
+// segment which is not followed by a letter, and so is not a placeholder.
+var invalidPlaceholderMatcher *regexp.Regexp = regexp.MustCompile(`(^|[./]):([^A-Za-z]|$)`)
+
+// lintInvalidPlaceholder flags patterns which look like they use a placeholder,
+// but which Match treats as literal text because the name is invalid.
+func lintInvalidPlaceholder(rule Rule) []Issue {
+	if invalidPlaceholderMatcher.MatchString(rule.Pattern.Host) || invalidPlaceholderMatcher.MatchString(rule.Pattern.Path) {
+		return []Issue{{
+			Line:    rule.Line,
+			Message: fmt.Sprintf("rule at line %d can never match: invalid placeholder", rule.Line),
+		}}
+	}
+	return nil
+}
+
+// lintNoHeaders flags rules with no headers, which are usually a mistake such
+// as a stray blank line or a missing indent.
+func lintNoHeaders(rule Rule) []Issue {
+	if len(rule.Headers) == 0 {
+		return []Issue{{
+			Line:    rule.Line,
+			Message: fmt.Sprintf("rule at line %d has no headers", rule.Line),
+		}}
+	}
+	return nil
+}
+
+// lintByteOrderMark flags a byte order mark before a pattern, which is often
+// added by editors on Windows. Only a path pattern can start with one, as an
+// absolute URL pattern, or a header name, with a byte order mark fails to parse.
+func lintByteOrderMark(rule Rule) []Issue {
+	if strings.HasPrefix(rule.Pattern.Path, "\uFEFF") {
+		return []Issue{{
+			Line:    rule.Line,
+			Message: fmt.Sprintf("rule at line %d starts with a byte order mark", rule.Line),
+		}}
+	}
+	return nil
+}
+
+// lintValueCharacters flags header values with characters outside ISO-8859-1
+// or control characters, which are often pasted in by accident, such as curly
+// quotes in a CSP.
+func lintValueCharacters(rule Rule) []Issue {
+	issues := []Issue{}
+	for _, header := range rule.Headers {
+		for _, r := range header.Value {
+			if r == utf8.RuneError || r > unicode.MaxLatin1 || (unicode.IsControl(r) && r != '\t') {
+				issues = append(issues, Issue{
+					Line:    rule.Line,
+					Message: fmt.Sprintf("rule at line %d: header %q value contains invalid character %q", rule.Line, header.Name, r),
+				})
+				break
+			}
+		}
+	}
+	return issues
+}
+
+// patternCharacters are the characters allowed in a formatted pattern. As
+// Cloudflare does not document which characters it accepts, these are those
+// RFC 3986 allows in a URL path, plus the backslash used to escape a splat.
+const patternCharacters = "ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz0123456789-._~!$&'()*+,;=:@/%\\"
+
+// lintPatternCharacters flags patterns with characters which are not allowed
+// in a URL, or a query string or fragment, which may be rejected on deploy.
+// Whitespace and control characters are percent-encoded by formatting, so are
+// checked as the escape sequence.
+func lintPatternCharacters(rule Rule) []Issue {
+	pattern := strings.TrimPrefix(formatPattern(rule.Pattern), "\uFEFF")
+	for _, r := range pattern {
+		if !strings.ContainsRune(patternCharacters, r) {
+			return []Issue{{
+				Line:    rule.Line,
+				Message: fmt.Sprintf("rule at line %d: pattern %q contains disallowed character %q", rule.Line, pattern, r),
+			}}
+		}
+	}
+	return nil
+}
+
+// cspDirectives are the directive names defined by CSP Level 3 and its
+// extensions, along with deprecated ones browsers still recognize.
+var cspDirectives = []string{
+	"base-uri", "block-all-mixed-content", "child-src", "connect-src",
+	"default-src", "fenced-frame-src", "font-src", "form-action",
+	"frame-ancestors", "frame-src", "img-src", "manifest-src", "media-src",
+	"navigate-to", "object-src", "plugin-types", "prefetch-src", "report-to",
+	"report-uri", "require-sri-for", "require-trusted-types-for", "sandbox",
+	"script-src", "script-src-attr", "script-src-elem", "style-src",
+	"style-src-attr", "style-src-elem", "trusted-types",
+	"upgrade-insecure-requests", "webrtc", "worker-src",
+}
+
+// lintCSPSyntax flags likely syntax errors in Content-Security-Policy values:
+// a comma, which starts a second policy rather than separating sources, an
+// unknown directive name, or a directive with an unbalanced quote.
+func lintCSPSyntax(rule Rule) []Issue {
+	issues := []Issue{}
+	issue := func(header Header, problem string) {
+		issues = append(issues, Issue{
+			Line:    rule.Line,
+			Message: fmt.Sprintf("rule at line %d: header %q %s", rule.Line, header.Name, problem),
+		})
+	}
+	for _, header := range rule.Headers {
+		if header.Detach || !strings.EqualFold(header.Name, "Content-Security-Policy") && !strings.EqualFold(header.Name, "Content-Security-Policy-Report-Only") {
+			continue
+		}
+		if strings.Contains(header.Value, ",") {
+			issue(header, "contains a comma, which separates policies rather than sources")
+		}
+		for _, directive := range strings.Split(header.Value, ";") {
+			fields := strings.Fields(directive)
+			if len(fields) == 0 {
+				continue
+			}
+			if name := strings.ToLower(fields[0]); !slices.Contains(cspDirectives, name) {
+				issue(header, fmt.Sprintf("has unknown directive %q", fields[0]))
+			}
+			if strings.Count(directive, "'")%2 != 0 {
+				issue(header, fmt.Sprintf("has an unbalanced quote in directive %q", strings.TrimSpace(directive)))
+			}
+		}
+	}
+	return issues
+}
+
+// OverlappingRules returns the index pairs of rules whose patterns can both
+// match some URL, such as "/a/*" and "/a/b", with the lower index first. This
+// is not an error, as every matching rule applies, but headers from
+// overlapping rules accumulate in ways which may be unexpected. A host rule
+// overlaps a rule without a host where their paths overlap.
+func (f File) OverlappingRules() [][2]int {
+	pairs := [][2]int{}
+	for i := range f {
+		for j := i + 1; j < len(f); j++ {
+			if f[i].overlaps(f[j]) {
+				pairs = append(pairs, [2]int{i, j})
+			}
+		}
+	}
+	return pairs
+}
+
+// overlaps reports whether the rule and other can both match some URL.
+func (r Rule) overlaps(other Rule) bool {
+	if r.Pattern.Host != "" && other.Pattern.Host != "" &&
+		!anyGlobsIntersect(globs(r.Pattern.Host, false), globs(other.Pattern.Host, false), '.') {
+		return false
+	}
+	// a splat in the path of a host pattern may span several segments
+	return anyGlobsIntersect(globs(r.Pattern.Path, r.Pattern.Host != ""), globs(other.Pattern.Path, other.Pattern.Host != ""), '/')
+}
+
+const (
+	// wildcard is a glob token matching anything except the delimiter.
+	wildcard = -1
+	// greedyWildcard is a glob token matching anything.
+	greedyWildcard = -2
+)
+
+// globs returns each way Match may match src, as a sequence of byte and
+// wildcard tokens. Only the first splat, or failing that the first
+// placeholder, is a wildcard, and any pattern also matches itself literally.
+// A splat is always greedy if greedy is set.
+func globs(src string, greedy bool) [][]int {
+	p := compilePattern(src)
+	out := [][]int{}
+	if p.splat != nil {
+		token := wildcard
+		if p.greedy || greedy {
+			token = greedyWildcard
+		}
+		out = append(out, glob(p.splat[0], token, p.splat[1]))
+	}
+	if p.placeholderChunks != nil {
+		out = append(out, glob(p.placeholderChunks[0], wildcard, p.placeholderChunks[1]))
+	}
+	return append(out, glob(p.literal, 0, ""))
+}
+
+// glob returns the tokens for prefix and suffix around a wildcard token, or
+// for prefix alone if token is zero.
+func glob(prefix string, token int, suffix string) []int {
+	tokens := []int{}
+	for i := 0; i < len(prefix); i++ {
+		tokens = append(tokens, int(prefix[i]))
+	}
+	if token == 0 {
+		return tokens
+	}
+	tokens = append(tokens, token)
+	for i := 0; i < len(suffix); i++ {
+		tokens = append(tokens, int(suffix[i]))
+	}
+	return tokens
+}
+
+func anyGlobsIntersect(a, b [][]int, delim byte) bool {
+	for _, x := range a {
+		for _, y := range b {
+			if globsIntersect(x, y, delim) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// globsIntersect reports whether some string matches both globs a and b.
+func globsIntersect(a, b []int, delim byte) bool {
+	seen := map[[2]int]bool{}
+	var walk func(i, j int) bool
+	walk = func(i, j int) bool {
+		if i == len(a) && j == len(b) {
+			return true
+		}
+		if seen[[2]int{i, j}] {
+			return false
+		}
+		seen[[2]int{i, j}] = true
+
+		wildA := i < len(a) && a[i] < 0
+		wildB := j < len(b) && b[j] < 0
+		switch {
+		// a wildcard may end, matching nothing more
+		case wildA && walk(i+1, j), wildB && walk(i, j+1):
+			return true
+		// or match a byte of the other glob
+		case wildA && j < len(b) && !wildB && (a[i] == greedyWildcard || b[j] != int(delim)) && walk(i, j+1):
+			return true
+		case wildB && i < len(a) && !wildA && (b[j] == greedyWildcard || a[i] != int(delim)) && walk(i+1, j):
+			return true
+		}
+		return i < len(a) && j < len(b) && !wildA && !wildB && a[i] == b[j] && walk(i+1, j+1)
+	}
+	return walk(0, 0)
+}
@@ -0,0 +1,252 @@
+package headers_test
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	headers "github.com/jmhobbs/cloudflare-headers-file"
+)
+
+func Test_File_Lint_InvalidPlaceholder(t *testing.T) {
+	r := strings.NewReader(`/secure/:1page
+  x-placeholder: :1page
+
+https://subdomain.:1domain.com/*
+  x-placeholder: :1domain
+
+/movies/:title
+  x-movie-name: :title
+
+/time/12:30
+  x-time: noon
+`)
+	file, err := headers.Parse(r)
+	assert.NoError(t, err)
+
+	assert.Equal(t, []headers.Issue{
+		{Line: 1, Message: "rule at line 1 can never match: invalid placeholder"},
+		{Line: 4, Message: "rule at line 4 can never match: invalid placeholder"},
+	}, file.Lint())
+}
+
+func Test_File_ValidateAllowlist(t *testing.T) {
+	r := strings.NewReader(`/*
+  X-Frame-Options: DENY
+  x-content-type-options: nosniff
+
+/admin/*
+  X-Powered-By: PHP
+  ! X-Robots-Tag
+  Server: Apache
+`)
+	file, err := headers.Parse(r)
+	assert.NoError(t, err)
+
+	allowed := []string{"X-Frame-Options", "X-Content-Type-Options"}
+	err = file.ValidateAllowlist(allowed)
+	assert.EqualError(t, err, `header "X-Powered-By" not in allowlist in rule at line 5
+header "Server" not in allowlist in rule at line 5`)
+
+	assert.NoError(t, file.ValidateAllowlist(append(allowed, "x-powered-by", "server")))
+}
+
+func Test_File_Lint_ValueCharacters(t *testing.T) {
+	r := strings.NewReader("\uFEFF/secure/page\n" +
+		"  Content-Security-Policy: default-src \u2018self\u2019\n" +
+		"  X-Latin: café\n" +
+		"  X-Tab: a\tb\n" +
+		"  X-Control: a\x01b\n" +
+		"  X-Invalid: \xff\xfe\n")
+	file, err := headers.Parse(r)
+	assert.NoError(t, err)
+
+	assert.Equal(t, []headers.Issue{
+		{Line: 1, Message: "rule at line 1 starts with a byte order mark"},
+		{Line: 1, Message: `rule at line 1: header "Content-Security-Policy" value contains invalid character '‘'`},
+		{Line: 1, Message: `rule at line 1: header "X-Control" value contains invalid character '\x01'`},
+		{Line: 1, Message: `rule at line 1: header "X-Invalid" value contains invalid character '�'`},
+	}, file.Lint())
+}
+
+func Test_Parse_ByteOrderMark(t *testing.T) {
+	// a byte order mark anywhere but before a path pattern fails to parse,
+	// rather than being reported by Lint
+	_, err := headers.Parse(strings.NewReader("\uFEFFhttps://example.com/*\n  X-Robots-Tag: noindex\n"))
+	assert.Error(t, err)
+
+	_, err = headers.Parse(strings.NewReader("/secure/page\n  \uFEFFX-Frame-Options: DENY\n"))
+	assert.Error(t, err)
+}
+
+func Test_File_Lint_NoHeaders(t *testing.T) {
+	input := `/secure/page
+
+/static/*
+  X-Robots-Tag: nosnippet
+
+/empty
+`
+	file, err := headers.Parse(strings.NewReader(input))
+	assert.NoError(t, err)
+
+	assert.Equal(t, []headers.Issue{
+		{Line: 1, Message: "rule at line 1 has no headers"},
+		{Line: 6, Message: "rule at line 6 has no headers"},
+	}, file.Lint())
+
+	_, err = headers.ParseWithOptions(strings.NewReader(input), headers.ParseOptions{Strict: true})
+	assert.EqualError(t, err, "rule at line 1 has no headers")
+}
+
+func Test_File_Lint_PatternCharacters(t *testing.T) {
+	r := strings.NewReader(`/secure/page
+  X-Frame-Options: DENY
+
+/search?q=*
+  X-Search: true
+
+/a|b
+  X-Pipe: true
+
+/my page
+  X-Space: true
+
+/café
+  X-Accent: true
+
+/a\*b/:name/~user/@scope
+  X-Allowed: true
+
+https://*.example.com/*
+  X-Host: true
+`)
+	file, err := headers.Parse(r)
+	assert.NoError(t, err)
+
+	assert.Equal(t, []headers.Issue{
+		{Line: 4, Message: `rule at line 4: pattern "/search?q=*" contains disallowed character '?'`},
+		{Line: 7, Message: `rule at line 7: pattern "/a|b" contains disallowed character '|'`},
+		{Line: 13, Message: `rule at line 13: pattern "/café" contains disallowed character 'é'`},
+	}, file.Lint())
+}
+
+func Test_File_Report(t *testing.T) {
+	r := strings.NewReader(`/secure/page
+  X-Frame-Options: DENY
+
+/movies/:1st
+  X-Movie: true
+
+/empty
+
+/a|b
+  X-Pipe: true
+`)
+	file, err := headers.Parse(r)
+	assert.NoError(t, err)
+
+	report := file.Report()
+	assert.Equal(t, headers.Report{Findings: []headers.Finding{
+		{Line: 4, Severity: headers.SeverityError, Code: "invalid-placeholder", Message: "rule at line 4 can never match: invalid placeholder"},
+		{Line: 7, Severity: headers.SeverityWarning, Code: "no-headers", Message: "rule at line 7 has no headers"},
+		{Line: 9, Severity: headers.SeverityError, Code: "pattern-characters", Message: `rule at line 9: pattern "/a|b" contains disallowed character '|'`},
+	}}, report)
+	assert.True(t, report.HasErrors())
+
+	data, err := json.Marshal(report.Findings[1])
+	assert.NoError(t, err)
+	assert.JSONEq(t, `{"line": 7, "severity": "warning", "code": "no-headers", "message": "rule at line 7 has no headers"}`, string(data))
+
+	// warnings alone are not errors
+	file, err = headers.Parse(strings.NewReader("/empty\n"))
+	assert.NoError(t, err)
+	assert.Len(t, file.Report().Findings, 1)
+	assert.False(t, file.Report().HasErrors())
+
+	assert.Empty(t, headers.File{}.Report().Findings)
+	assert.False(t, headers.File{}.Report().HasErrors())
+}
+
+func Test_File_OverlappingRules(t *testing.T) {
+	tests := []struct {
+		name     string
+		a, b     string
+		overlaps bool
+	}{
+		{"splat and literal", "/a/*", "/a/b", true},
+		{"different prefixes", "/a/*", "/b/*", false},
+		{"splat and nested literal", "/a/*", "/a/b/c", false},
+		{"greedy splat and nested literal", "/a/**", "/a/b/c", true},
+		{"placeholder and splat", "/movies/:title", "/movies/*", true},
+		{"different suffixes", "/*.css", "/*.js", false},
+		{"prefix and suffix", "/style.*", "/*.css", true},
+		{"escaped splat", `/a\*`, "/ab", false},
+		{"identical literals", "/a", "/a", true},
+		{"host and path", "https://example.com/*", "/a", true},
+		{"host splat and nested path", "https://example.com/*", "/a/b", true},
+		{"host and different path", "https://example.com/about", "/a", false},
+		{"same host and different paths", "https://example.com/a", "https://example.com/b", false},
+		{"different hosts", "https://a.example.com/", "https://b.example.com/", false},
+		{"host placeholder", "https://:sub.example.com/", "https://www.example.com/", true},
+		{"host splat and nested host", "https://*.example.com/", "https://a.b.example.com/", false},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			file, err := headers.Parse(strings.NewReader(test.a + "\n  X-A: a\n" + test.b + "\n  X-B: b\n"))
+			assert.NoError(t, err)
+
+			expected := [][2]int{}
+			if test.overlaps {
+				expected = append(expected, [2]int{0, 1})
+			}
+			assert.Equal(t, expected, file.OverlappingRules())
+		})
+	}
+}
+
+func Test_File_OverlappingRules_Pairs(t *testing.T) {
+	r := strings.NewReader(`/*
+  X-Frame-Options: DENY
+
+/static/*
+  Cache-Control: max-age=3600
+
+/index.html
+  X-Index: true
+
+/static/app.js
+  Cache-Control: no-cache
+`)
+	file, err := headers.Parse(r)
+	assert.NoError(t, err)
+
+	assert.Equal(t, [][2]int{{0, 2}, {1, 3}}, file.OverlappingRules())
+}
+
+func Test_File_ReportWithOptions_CSP(t *testing.T) {
+	r := strings.NewReader(`/secure/page
+  Content-Security-Policy: default-src 'self; img-src *
+
+/valid
+  Content-Security-Policy: default-src 'self'; script-src 'self' 'nonce-abc' https://cdn.example.com; upgrade-insecure-requests;
+
+/mistakes
+  content-security-policy-report-only: default-src 'self', https://cdn.example.com; defualt-src 'none'
+  X-Other: a, 'b
+  ! Content-Security-Policy
+`)
+	file, err := headers.Parse(r)
+	assert.NoError(t, err)
+
+	// the check is opt-in
+	assert.Empty(t, file.Report().Findings)
+
+	assert.Equal(t, headers.Report{Findings: []headers.Finding{
+		{Line: 1, Severity: headers.SeverityWarning, Code: "csp-syntax", Message: `rule at line 1: header "Content-Security-Policy" has an unbalanced quote in directive "default-src 'self"`},
+		{Line: 7, Severity: headers.SeverityWarning, Code: "csp-syntax", Message: `rule at line 7: header "content-security-policy-report-only" contains a comma, which separates policies rather than sources`},
+		{Line: 7, Severity: headers.SeverityWarning, Code: "csp-syntax", Message: `rule at line 7: header "content-security-policy-report-only" has unknown directive "defualt-src"`},
+	}}, file.ReportWithOptions(headers.LintOptions{CSP: true}))
+}
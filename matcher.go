@@ -0,0 +1,161 @@
+package headers
+
+import (
+	"errors"
+	"io"
+	"net/url"
+	"sync/atomic"
+)
+
+// Matcher matches URLs against the rules of a File, for long-lived use by a
+// server. It is safe for concurrent use, including while being reloaded.
+type Matcher struct {
+	opts      MatchOptions
+	parseOpts ParseOptions
+	count     bool
+	compiled  bool
+	loaded    atomic.Pointer[matcherRules]
+}
+
+// matcherRules are the rules of a Matcher, which are replaced as a whole.
+type matcherRules struct {
+	file File
+	// compiled are the rules of file with their patterns compiled, when the
+	// Matcher was created by Compile
+	compiled []compiledRule
+	counts   []atomic.Uint64
+}
+
+// MatcherOption configures a Matcher.
+type MatcherOption func(*Matcher)
+
+// WithMatchOptions sets the options used when matching.
+func WithMatchOptions(opts MatchOptions) MatcherOption {
+	return func(m *Matcher) {
+		m.opts = opts
+	}
+}
+
+// WithParseOptions sets the options used when parsing the file data given to
+// Reload, which should be those the file the Matcher was created for was
+// parsed with.
+func WithParseOptions(opts ParseOptions) MatcherOption {
+	return func(m *Matcher) {
+		m.parseOpts = opts
+	}
+}
+
+// WithMatchCounts enables counting how many times each rule has matched,
+// which is reported by MatchCounts.
+func WithMatchCounts() MatcherOption {
+	return func(m *Matcher) {
+		m.count = true
+	}
+}
+
+// NewMatcher creates a Matcher for the rules of the file.
+func NewMatcher(f File, opts ...MatcherOption) *Matcher {
+	m := &Matcher{}
+	for _, opt := range opts {
+		opt(m)
+	}
+	// without compiling, loading cannot fail
+	_ = m.load(f)
+	return m
+}
+
+// Compile creates a Matcher for the rules of the file, as NewMatcher does, but
+// compiles every rule pattern up front rather than for each URL matched. An
+// error is returned if any pattern has a placeholder which is invalid, and so
+// can never match. A Matcher created by Compile also compiles the rules it is
+// reloaded with.
+func (f File) Compile(opts ...MatcherOption) (*Matcher, error) {
+	m := &Matcher{compiled: true}
+	for _, opt := range opts {
+		opt(m)
+	}
+	if err := m.load(f); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func (m *Matcher) load(f File) error {
+	rules := &matcherRules{file: f}
+	if m.compiled {
+		errs := []error{}
+		rules.compiled = make([]compiledRule, len(f))
+		for i, rule := range f {
+			for _, issue := range lintInvalidPlaceholder(rule) {
+				errs = append(errs, errors.New(issue.Message))
+			}
+			rules.compiled[i] = rule.compile()
+		}
+		if err := errors.Join(errs...); err != nil {
+			return err
+		}
+	}
+	if m.count {
+		rules.counts = make([]atomic.Uint64, len(f))
+	}
+	m.loaded.Store(rules)
+	return nil
+}
+
+// Reload parses the _headers file data from the input reader, with the
+// options set by WithParseOptions, and replaces the rules of the Matcher with
+// it. Concurrent calls to Match see either the old or
+// the new rules, never a mix of both. If parsing fails, or compiling for a
+// Matcher created by Compile, the old rules are kept. Match counts are reset,
+// as rule indexes refer to the new rules.
+func (m *Matcher) Reload(in io.Reader) error {
+	f, err := ParseWithOptions(in, m.parseOpts)
+	if err != nil {
+		return err
+	}
+	return m.load(*f)
+}
+
+// Match all the rules against the input URL, returning the headers to apply.
+func (m *Matcher) Match(in url.URL) []string {
+	rules := m.loaded.Load()
+	var matches []RuleMatch
+	if rules.compiled != nil {
+		matches = matchCompiled(rules.compiled, in, m.opts)
+	} else {
+		matches = rules.file.matchDetail(in, m.opts)
+	}
+	if rules.counts != nil {
+		for _, match := range matches {
+			rules.counts[match.Index].Add(1)
+		}
+	}
+	return m.opts.flatten(matchedHeaders(matches, m.opts))
+}
+
+// MatchCounts returns how many times each rule, by index, has matched since
+// the Matcher was created or last reloaded. It is empty unless the Matcher
+// was created using WithMatchCounts.
+func (m *Matcher) MatchCounts() map[int]uint64 {
+	rules := m.loaded.Load()
+	counts := make(map[int]uint64, len(rules.counts))
+	for i := range rules.counts {
+		counts[i] = rules.counts[i].Load()
+	}
+	return counts
+}
+
+// matchCompiled matches the compiled rules against the input URL, as
+// File.matchDetail does.
+func matchCompiled(rules []compiledRule, in url.URL, opts MatchOptions) []RuleMatch {
+	matches := []RuleMatch{}
+	for i, compiled := range rules {
+		if bindings, ok := compiled.match(in, opts); ok {
+			if bindings == nil {
+				bindings = map[string]string{}
+			}
+			matches = append(matches, RuleMatch{Index: i, Rule: compiled.rule, Bindings: bindings})
+		}
+	}
+	return matches
+}
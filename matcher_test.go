@@ -0,0 +1,230 @@
+package headers_test
+
+import (
+	"fmt"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	headers "github.com/jmhobbs/cloudflare-headers-file"
+)
+
+func Test_Matcher_Match(t *testing.T) {
+	r := strings.NewReader(`/Secure/Page
+  X-Frame-Options: DENY
+`)
+	file, err := headers.Parse(r)
+	assert.NoError(t, err)
+
+	input, err := url.Parse("https://example.com/secure/page")
+	assert.NoError(t, err)
+
+	assert.Empty(t, headers.NewMatcher(*file).Match(*input))
+
+	matcher := headers.NewMatcher(*file, headers.WithMatchOptions(headers.MatchOptions{CaseInsensitivePath: true}))
+	assert.Equal(t, []string{"X-Frame-Options: DENY"}, matcher.Match(*input))
+}
+
+func Test_Matcher_MatchCounts(t *testing.T) {
+	r := strings.NewReader(`/*
+  X-Frame-Options: DENY
+
+/static/*
+  X-Robots-Tag: nosnippet
+
+/unused
+  X-Unused: true
+`)
+	file, err := headers.Parse(r)
+	assert.NoError(t, err)
+
+	page, err := url.Parse("https://example.com/page")
+	assert.NoError(t, err)
+	asset, err := url.Parse("https://example.com/static/app.js")
+	assert.NoError(t, err)
+
+	assert.Empty(t, headers.NewMatcher(*file).MatchCounts())
+
+	matcher := headers.NewMatcher(*file, headers.WithMatchCounts())
+
+	var wg sync.WaitGroup
+	for i := 0; i < 50; i++ {
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			matcher.Match(*page)
+		}()
+		go func() {
+			defer wg.Done()
+			matcher.Match(*asset)
+		}()
+	}
+	wg.Wait()
+
+	assert.Equal(t, map[int]uint64{0: 50, 1: 50, 2: 0}, matcher.MatchCounts())
+}
+
+func Test_Matcher_Reload(t *testing.T) {
+	before := "/*\n  X-Version: 1\n  X-Config: old\n"
+	after := "/*\n  X-Version: 2\n  X-Config: new\n"
+
+	file, err := headers.Parse(strings.NewReader(before))
+	assert.NoError(t, err)
+
+	matcher := headers.NewMatcher(*file, headers.WithMatchCounts())
+
+	input, err := url.Parse("https://example.com/page")
+	assert.NoError(t, err)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 200; j++ {
+				out := matcher.Match(*input)
+				sort.Strings(out)
+				// never a mix of the old and new rules
+				if !assert.Contains(t, [][]string{
+					{"X-Config: old", "X-Version: 1"},
+					{"X-Config: new", "X-Version: 2"},
+				}, out) {
+					return
+				}
+			}
+		}()
+	}
+	for i := 0; i < 50; i++ {
+		config := before
+		if i%2 == 0 {
+			config = after
+		}
+		assert.NoError(t, matcher.Reload(strings.NewReader(config)))
+	}
+	wg.Wait()
+
+	assert.NoError(t, matcher.Reload(strings.NewReader(after)))
+	assert.Equal(t, map[int]uint64{0: 0}, matcher.MatchCounts())
+	assert.ElementsMatch(t, []string{"X-Version: 2", "X-Config: new"}, matcher.Match(*input))
+
+	// a failed reload keeps the current rules
+	assert.Error(t, matcher.Reload(strings.NewReader("  X-Orphan: true\n")))
+	assert.ElementsMatch(t, []string{"X-Version: 2", "X-Config: new"}, matcher.Match(*input))
+}
+
+func Test_Matcher_Reload_ParseOptions(t *testing.T) {
+	opts := headers.ParseOptions{
+		ExpandEnv:    true,
+		LookupEnv:    func(string) (string, bool) { return "42", true },
+		PrependSlash: true,
+	}
+	config := "page\n  X-Build: ${BUILD_ID}\n"
+
+	file, err := headers.ParseWithOptions(strings.NewReader(config), opts)
+	assert.NoError(t, err)
+	matcher := headers.NewMatcher(*file, headers.WithParseOptions(opts))
+
+	input, err := url.Parse("https://example.com/page")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"X-Build: 42"}, matcher.Match(*input))
+
+	// the file is reloaded with the same options it was first parsed with
+	assert.NoError(t, matcher.Reload(strings.NewReader(config)))
+	assert.Equal(t, []string{"X-Build: 42"}, matcher.Match(*input))
+}
+
+func Test_File_Compile(t *testing.T) {
+	r := strings.NewReader(`/movies/:title
+  X-Movie-Name: :title
+
+/static/*
+  X-Static: :splat
+
+https://:sub.example.com/*
+  X-Subdomain: :sub
+`)
+	file, err := headers.Parse(r)
+	assert.NoError(t, err)
+
+	matcher, err := file.Compile(headers.WithMatchCounts())
+	assert.NoError(t, err)
+
+	for _, u := range []string{
+		"https://www.example.com/movies/jaws",
+		"https://example.com/static/app.js",
+		"https://example.com/static/js/app.js",
+		"https://example.org/movies/",
+	} {
+		input, err := url.Parse(u)
+		assert.NoError(t, err)
+		assert.Equal(t, headers.NewMatcher(*file).Match(*input), matcher.Match(*input), u)
+	}
+	assert.Equal(t, map[int]uint64{0: 2, 1: 1, 2: 1}, matcher.MatchCounts())
+
+	// the reloaded rules are compiled too
+	assert.NoError(t, matcher.Reload(strings.NewReader("/movies/:title\n  X-Title: :title\n")))
+	input, err := url.Parse("https://example.com/movies/jaws")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"X-Title: jaws"}, matcher.Match(*input))
+
+	assert.EqualError(t, matcher.Reload(strings.NewReader("/secure/:1page\n  X-Page: :1page\n")), "rule at line 1 can never match: invalid placeholder")
+	assert.Equal(t, []string{"X-Title: jaws"}, matcher.Match(*input))
+}
+
+func Test_File_Compile_InvalidPlaceholder(t *testing.T) {
+	r := strings.NewReader(`/secure/:1page
+  x-placeholder: :1page
+
+/movies/:title
+  x-movie-name: :title
+
+https://subdomain.:1domain.com/*
+  x-placeholder: :1domain
+`)
+	file, err := headers.Parse(r)
+	assert.NoError(t, err)
+
+	matcher, err := file.Compile()
+	assert.Nil(t, matcher)
+	assert.EqualError(t, err, "rule at line 1 can never match: invalid placeholder\nrule at line 7 can never match: invalid placeholder")
+}
+
+func benchmarkPlaceholderFile(b *testing.B) headers.File {
+	var sb strings.Builder
+	for i := 0; i < 500; i++ {
+		fmt.Fprintf(&sb, "/section-%d/:page\n  X-Page: :page\n\n", i)
+	}
+
+	file, err := headers.Parse(strings.NewReader(sb.String()))
+	if err != nil {
+		b.Fatal(err)
+	}
+	return *file
+}
+
+func Benchmark_Matcher_Match_Lazy(b *testing.B) {
+	matcher := headers.NewMatcher(benchmarkPlaceholderFile(b))
+	input, _ := url.Parse("https://example.com/section-499/page")
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		matcher.Match(*input)
+	}
+}
+
+func Benchmark_Matcher_Match_Compiled(b *testing.B) {
+	matcher, err := benchmarkPlaceholderFile(b).Compile()
+	if err != nil {
+		b.Fatal(err)
+	}
+	input, _ := url.Parse("https://example.com/section-499/page")
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		matcher.Match(*input)
+	}
+}
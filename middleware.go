@@ -0,0 +1,57 @@
+package headers
+
+import (
+	"net/http"
+	"strings"
+)
+
+// MiddlewareOption configures the middleware returned by File.MiddlewareWith.
+type MiddlewareOption func(*middleware)
+
+type middleware struct {
+	onMatch func(r *http.Request, applied []string)
+}
+
+// WithOnMatch sets a function called with each request and the headers applied
+// to its response, in the format returned by File.Match, before the next
+// handler is called. It is called even if no headers were applied.
+func WithOnMatch(fn func(r *http.Request, applied []string)) MiddlewareOption {
+	return func(m *middleware) {
+		m.onMatch = fn
+	}
+}
+
+// Middleware returns HTTP middleware which adds the headers matching each
+// request, as found by MatchRequest, to the response before calling the next
+// handler. Header values containing a carriage return, line feed or NUL are
+// never applied.
+func (f File) Middleware() func(http.Handler) http.Handler {
+	return f.MiddlewareWith()
+}
+
+// MiddlewareWith returns HTTP middleware like Middleware, configured with the
+// provided options.
+func (f File) MiddlewareWith(opts ...MiddlewareOption) func(http.Handler) http.Handler {
+	m := &middleware{}
+	for _, opt := range opts {
+		opt(m)
+	}
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			applied := []string{}
+			for _, header := range f.MatchRequest(r) {
+				name, value, _ := strings.Cut(header, ": ")
+				if !validValue(value) {
+					continue
+				}
+				w.Header().Add(name, value)
+				applied = append(applied, header)
+			}
+			if m.onMatch != nil {
+				m.onMatch(r, applied)
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
@@ -0,0 +1,83 @@
+package headers_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	headers "github.com/jmhobbs/cloudflare-headers-file"
+)
+
+var okHandler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+	w.WriteHeader(http.StatusOK)
+})
+
+func Test_File_Middleware(t *testing.T) {
+	r := strings.NewReader(`/secure/*
+  X-Frame-Options: DENY
+  X-Robots-Tag: noindex
+`)
+	file, err := headers.Parse(r)
+	assert.NoError(t, err)
+
+	handler := file.Middleware()(okHandler)
+
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest("GET", "https://example.com/secure/page", nil))
+	assert.Equal(t, "DENY", rec.Header().Get("X-Frame-Options"))
+	assert.Equal(t, "noindex", rec.Header().Get("X-Robots-Tag"))
+
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest("GET", "https://example.com/public", nil))
+	assert.Empty(t, rec.Header().Get("X-Frame-Options"))
+}
+
+func Test_File_Middleware_InvalidValues(t *testing.T) {
+	file := headers.File{
+		{
+			Pattern: url.URL{Path: "/*"},
+			Headers: []headers.Header{
+				{Name: "X-Split", Value: "a\r\nSet-Cookie: b"},
+				{Name: "X-Fine", Value: "ok"},
+			},
+		},
+	}
+
+	rec := httptest.NewRecorder()
+	file.Middleware()(okHandler).ServeHTTP(rec, httptest.NewRequest("GET", "https://example.com/page", nil))
+	assert.Empty(t, rec.Header().Values("X-Split"))
+	assert.Empty(t, rec.Header().Values("Set-Cookie"))
+	assert.Equal(t, "ok", rec.Header().Get("X-Fine"))
+}
+
+func Test_File_MiddlewareWith_OnMatch(t *testing.T) {
+	r := strings.NewReader(`/secure/*
+  X-Frame-Options: DENY
+  X-Robots-Tag: noindex
+`)
+	file, err := headers.Parse(r)
+	assert.NoError(t, err)
+
+	var (
+		calls   int
+		path    string
+		applied []string
+	)
+	handler := file.MiddlewareWith(headers.WithOnMatch(func(r *http.Request, a []string) {
+		calls++
+		path, applied = r.URL.Path, a
+	}))(okHandler)
+
+	handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "https://example.com/secure/page", nil))
+	assert.Equal(t, 1, calls)
+	assert.Equal(t, "/secure/page", path)
+	assert.ElementsMatch(t, []string{"X-Frame-Options: DENY", "X-Robots-Tag: noindex"}, applied)
+
+	handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "https://example.com/public", nil))
+	assert.Equal(t, 2, calls)
+	assert.Empty(t, applied)
+}
@@ -0,0 +1,85 @@
+package headers
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// ToNginx translates the rules into nginx location blocks of add_header
+// directives. Splats and placeholders become named regular expression
+// captures, referenced as variables in header values.
+//
+// The translation is approximate. nginx applies the headers of a single
+// location to each request, rather than combining every matching rule, and
+// has no equivalent of detaching a header or matching on host within a
+// location. Rules or headers which cannot be translated are reported as
+// comments in the output.
+func (f File) ToNginx() string {
+	blocks := []string{}
+	for _, rule := range f {
+		blocks = append(blocks, nginxLocation(rule))
+	}
+	return strings.Join(blocks, "\n")
+}
+
+func nginxLocation(rule Rule) string {
+	if rule.Pattern.Host != "" {
+		return fmt.Sprintf("# unsupported: host pattern %q\n", formatPattern(rule.Pattern))
+	}
+
+	var b strings.Builder
+	location, bindings, ok := nginxPath(rule.Pattern.Path)
+	if !ok {
+		return fmt.Sprintf("# unsupported: pattern %q\n", formatPattern(rule.Pattern))
+	}
+	b.WriteString("location " + location + " {\n")
+	for _, header := range rule.Headers {
+		// nginx has no escape for a literal $, which would be read as a variable
+		if header.Detach || strings.Contains(header.Value, "$") {
+			fmt.Fprintf(&b, "    # unsupported: %s\n", header)
+			continue
+		}
+		fmt.Fprintf(&b, "    add_header %s %s always;\n", header.Name, nginxQuote(replacedValue(header.Value, bindings)))
+	}
+	b.WriteString("}\n")
+	return b.String()
+}
+
+// nginxPath returns the location modifier and path for a pattern, along with
+// the nginx variable each splat or placeholder is captured into.
+func nginxPath(path string) (string, map[string]string, bool) {
+	chunks, greedy := splitSplat(path)
+	capture := "[^/]*"
+	if greedy {
+		capture = ".*"
+	}
+	switch {
+	case len(chunks) == 2:
+		return "~ " + nginxArgument("^"+regexp.QuoteMeta(chunks[0])+"(?<splat>"+capture+")"+regexp.QuoteMeta(chunks[1])+"$"),
+			map[string]string{":splat": "$splat"}, true
+	case len(chunks) > 2:
+		return "", nil, false
+	}
+
+	if placeholder := placeholderMatcher.FindString(path); placeholder != "" {
+		parts := strings.SplitN(path, placeholder, 2)
+		return "~ " + nginxArgument("^"+regexp.QuoteMeta(unescapeSplat(parts[0]))+"(?<"+placeholder[1:]+">[^/]*)"+regexp.QuoteMeta(unescapeSplat(parts[1]))+"$"),
+			map[string]string{placeholder: "$" + placeholder[1:]}, true
+	}
+
+	return "= " + nginxArgument(unescapeSplat(path)), nil, true
+}
+
+// nginxArgument quotes a directive argument if it contains whitespace or any
+// character nginx would otherwise read as syntax.
+func nginxArgument(arg string) string {
+	if strings.ContainsAny(arg, " \t{};\"'") {
+		return nginxQuote(arg)
+	}
+	return arg
+}
+
+func nginxQuote(value string) string {
+	return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(value) + `"`
+}
@@ -0,0 +1,68 @@
+package headers_test
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	headers "github.com/jmhobbs/cloudflare-headers-file"
+)
+
+func Test_File_ToNginx(t *testing.T) {
+	r := strings.NewReader(`/secure/page
+  X-Frame-Options: DENY
+  Content-Security-Policy: default-src "self"
+
+/static/*.js
+  X-File: :splat
+
+/movies/:title
+  X-Movie: You are watching :title
+  ! X-Frame-Options
+
+https://example.com/*
+  X-Robots-Tag: noindex
+`)
+	file, err := headers.Parse(r)
+	assert.NoError(t, err)
+
+	assert.Equal(t, `location = /secure/page {
+    add_header X-Frame-Options "DENY" always;
+    add_header Content-Security-Policy "default-src \"self\"" always;
+}
+
+location ~ ^/static/(?<splat>[^/]*)\.js$ {
+    add_header X-File "$splat" always;
+}
+
+location ~ ^/movies/(?<title>[^/]*)$ {
+    add_header X-Movie "You are watching $title" always;
+    # unsupported: ! X-Frame-Options
+}
+
+# unsupported: host pattern "https://example.com/*"
+`, file.ToNginx())
+}
+
+func Test_File_ToNginx_Quoting(t *testing.T) {
+	r := strings.NewReader(`/my%20page
+  X-Price: costs $5
+  X-Page: my page
+
+/my%20files/*
+  X-File: :splat
+`)
+	file, err := headers.Parse(r)
+	assert.NoError(t, err)
+
+	assert.Equal(t, `location = "/my page" {
+    # unsupported: X-Price: costs $5
+    add_header X-Page "my page" always;
+}
+
+location ~ "^/my files/(?<splat>[^/]*)$" {
+    add_header X-File "$splat" always;
+}
+`, file.ToNginx())
+}
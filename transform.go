@@ -0,0 +1,280 @@
+package headers
+
+import (
+	"fmt"
+	"net/url"
+	"regexp"
+	"slices"
+	"strings"
+)
+
+// ToReportOnly returns a copy of the file where every Content-Security-Policy
+// header, including detaches, is renamed to Content-Security-Policy-Report-Only.
+func (f File) ToReportOnly() File {
+	out := f.clone()
+	for _, rule := range out {
+		for i, header := range rule.Headers {
+			if strings.EqualFold(header.Name, "Content-Security-Policy") {
+				rule.Headers[i].Name = "Content-Security-Policy-Report-Only"
+			}
+		}
+	}
+	return out
+}
+
+// ExpandWWW returns a copy of the file where every host-specific rule is
+// followed by an identical rule for the www variant of its host, or for the
+// bare host if the rule is for a www host. Inserting each copy directly after
+// its original keeps header precedence unchanged, as a request can only ever
+// match one of the two. Hosts whose first label is a splat or placeholder
+// already match the www variant, and are left alone. They do not match the
+// bare host, such as example.com for https://*.example.com/*, which is not
+// added, as its splat or placeholder would have no value to substitute.
+func (f File) ExpandWWW() File {
+	out := File{}
+	for _, rule := range f.clone() {
+		out = append(out, rule)
+
+		host := rule.Pattern.Host
+		if host == "" {
+			continue
+		}
+
+		label, _, _ := strings.Cut(host, ".")
+		if strings.Contains(label, "*") || placeholderMatcher.MatchString(label) {
+			continue
+		}
+
+		variant := rule
+		variant.Headers = append([]Header{}, rule.Headers...)
+		if bare, ok := strings.CutPrefix(host, "www."); ok {
+			variant.Pattern.Host = bare
+		} else {
+			variant.Pattern.Host = "www." + host
+		}
+		out = append(out, variant)
+	}
+	return out
+}
+
+// RenamePlaceholder renames the placeholder :old to :new in the patterns of
+// every rule which uses it, and in the header values of those rules, returning
+// the number of replacements made. Values of other rules are left alone, as
+// there :old is not a placeholder. An error is returned if new is not a valid
+// placeholder name, and the file is not changed.
+func (f *File) RenamePlaceholder(old, new string) (int, error) {
+	if !placeholderNameMatcher.MatchString(new) {
+		return 0, fmt.Errorf("invalid placeholder name: %q", new)
+	}
+
+	count := 0
+	renamePattern := func(s string) string {
+		locs := placeholderMatcher.FindAllStringIndex(s, -1)
+		for i := len(locs) - 1; i >= 0; i-- {
+			if s[locs[i][0]+1:locs[i][1]] == old {
+				s = s[:locs[i][0]] + ":" + new + s[locs[i][1]:]
+				count++
+			}
+		}
+		return s
+	}
+	matcher := regexp.MustCompile(":" + regexp.QuoteMeta(old) + `\b`)
+	renameValue := func(s string) string {
+		return matcher.ReplaceAllStringFunc(s, func(string) string {
+			count++
+			return ":" + new
+		})
+	}
+
+	for i := range *f {
+		rule := &(*f)[i]
+		host, path := renamePattern(rule.Pattern.Host), renamePattern(rule.Pattern.Path)
+		if host == rule.Pattern.Host && path == rule.Pattern.Path {
+			continue
+		}
+		rule.Pattern.Host, rule.Pattern.Path = host, path
+		for j := range rule.Headers {
+			rule.Headers[j].Value = renameValue(rule.Headers[j].Value)
+		}
+	}
+	return count, nil
+}
+
+// valueNormalizers canonicalize the values of headers whose syntax is known,
+// keyed by lowercase header name.
+var valueNormalizers = map[string]func(string) string{
+	// nosniff is the only valid value
+	"x-content-type-options": strings.ToLower,
+	// DENY and SAMEORIGIN are conventionally upper case
+	"x-frame-options": normalizeFrameOptions,
+	// policy tokens are case-insensitive, and conventionally lower case
+	"referrer-policy":                     strings.ToLower,
+	"content-security-policy":             normalizeCSP,
+	"content-security-policy-report-only": normalizeCSP,
+}
+
+// NormalizeValues returns a copy of the file with header values canonicalized.
+// Runs of whitespace in every value are collapsed to a single space. Beyond
+// that, only headers with well known syntax are changed:
+//
+//   - X-Content-Type-Options is lower cased
+//   - X-Frame-Options has its directive upper cased, leaving the case of
+//     any ALLOW-FROM origin
+//   - Referrer-Policy is lower cased
+//   - Content-Security-Policy and Content-Security-Policy-Report-Only have
+//     empty directives from stray semicolons removed, and remaining
+//     directives separated by "; "
+func (f File) NormalizeValues() File {
+	out := f.clone()
+	for _, rule := range out {
+		for i, header := range rule.Headers {
+			if header.Detach {
+				continue
+			}
+			value := strings.Join(strings.Fields(header.Value), " ")
+			if normalize, ok := valueNormalizers[strings.ToLower(header.Name)]; ok {
+				value = normalize(value)
+			}
+			rule.Headers[i].Value = value
+		}
+	}
+	return out
+}
+
+func normalizeFrameOptions(value string) string {
+	directive, origin, found := strings.Cut(value, " ")
+	if !found {
+		return strings.ToUpper(directive)
+	}
+	return strings.ToUpper(directive) + " " + origin
+}
+
+func normalizeCSP(value string) string {
+	directives := []string{}
+	for _, directive := range strings.Split(value, ";") {
+		if directive = strings.TrimSpace(directive); directive != "" {
+			directives = append(directives, directive)
+		}
+	}
+	return strings.Join(directives, "; ")
+}
+
+// securityDefaults are the headers added by WithSecurityDefaults.
+var securityDefaults = []Header{
+	{Name: "X-Content-Type-Options", Value: "nosniff"},
+	{Name: "X-Frame-Options", Value: "DENY"},
+	{Name: "Referrer-Policy", Value: "no-referrer"},
+}
+
+// WithSecurityDefaults returns a copy of the file which sets an opinionated
+// set of common security headers on every path:
+//
+//	X-Content-Type-Options: nosniff
+//	X-Frame-Options: DENY
+//	Referrer-Policy: no-referrer
+//
+// It is purely additive, and existing values are never overwritten or merged
+// with. Defaults are added to a "/*" rule at the start of the file, reusing
+// one which is already first, so that later rules may still detach them. A
+// default that rule already sets is skipped, and any other rule which sets a
+// default header first detaches the default value, so its own value applies
+// to its paths instead.
+func (f File) WithSecurityDefaults() File {
+	out := f.clone()
+	if len(out) == 0 || out[0].Pattern.Host != "" || out[0].Pattern.Path != "/*" {
+		out = append(File{{Pattern: url.URL{Path: "/*"}, Headers: []Header{}}}, out...)
+	}
+
+	for _, header := range securityDefaults {
+		if slices.ContainsFunc(out[0].Headers, func(h Header) bool { return !h.Detach && strings.EqualFold(h.Name, header.Name) }) {
+			continue
+		}
+		out[0].Headers = append(out[0].Headers, header)
+
+		for i := 1; i < len(out); i++ {
+			j := slices.IndexFunc(out[i].Headers, func(h Header) bool { return !h.Detach && strings.EqualFold(h.Name, header.Name) })
+			if j >= 0 {
+				// only the default value, so values from other rules still merge
+				out[i].Headers = slices.Insert(out[i].Headers, j, Header{Name: out[i].Headers[j].Name, Value: header.Value, Detach: true})
+			}
+		}
+	}
+	return out
+}
+
+// Expand matches every URL against the rules, returning a file with one rule
+// per URL which sets the headers that URL receives, with placeholders and
+// splats already substituted. A URL with a host gets a rule for exactly that
+// host and path, and one without a host a rule for the path on any host, so
+// the result may be matched without any dynamic patterns and gives the same
+// headers for each URL. Duplicate URLs are expanded once, and detaches are
+// already applied, so the rules only set headers.
+//
+// An error is returned if a path contains a placeholder, which a pattern has
+// no way to match literally, or is given both with and without a host, as the
+// rule for the path alone would also apply to the URL with the host.
+func (f File) Expand(urls []url.URL) (File, error) {
+	withHost, withoutHost := map[string]bool{}, map[string]bool{}
+	for _, in := range urls {
+		if in.Hostname() != "" {
+			withHost[in.Path] = true
+		} else {
+			withoutHost[in.Path] = true
+		}
+	}
+
+	out := File{}
+	seen := map[[2]string]bool{}
+	for _, in := range urls {
+		host := in.Hostname()
+		if seen[[2]string{host, in.Path}] {
+			continue
+		}
+		seen[[2]string{host, in.Path}] = true
+
+		if placeholderMatcher.MatchString(host + in.Path) {
+			return nil, fmt.Errorf("cannot expand %q: contains a placeholder", in.String())
+		}
+		if withHost[in.Path] && withoutHost[in.Path] {
+			return nil, fmt.Errorf("cannot expand %q: path given both with and without a host", in.Path)
+		}
+
+		pattern := url.URL{Path: strings.ReplaceAll(in.Path, "*", `\*`)}
+		if host != "" {
+			pattern.Scheme, pattern.Host = "https", host
+		}
+		out = append(out, Rule{Pattern: pattern, Headers: f.MatchResolved(in)})
+	}
+	return out, nil
+}
+
+// Minimize returns a copy of the file with only the rules which match at
+// least one of the URLs, in their original order and with all their headers,
+// including detaches. Matching any of the URLs against the result gives the
+// same headers as matching it against the whole file.
+func (f File) Minimize(urls []url.URL) File {
+	used := make([]bool, len(f))
+	for _, in := range urls {
+		for _, i := range f.MatchChain(in) {
+			used[i] = true
+		}
+	}
+
+	out := File{}
+	for i, rule := range f.clone() {
+		if used[i] {
+			out = append(out, rule)
+		}
+	}
+	return out
+}
+
+// clone returns a copy of the file which shares no header slices with f.
+func (f File) clone() File {
+	out := make(File, len(f))
+	for i, rule := range f {
+		out[i] = rule
+		out[i].Headers = append([]Header{}, rule.Headers...)
+	}
+	return out
+}
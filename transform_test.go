@@ -0,0 +1,420 @@
+package headers_test
+
+import (
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	headers "github.com/jmhobbs/cloudflare-headers-file"
+)
+
+func Test_File_ToReportOnly(t *testing.T) {
+	r := strings.NewReader(`/*
+  Content-Security-Policy: default-src 'self';
+  X-Frame-Options: DENY
+
+/*.jpg
+  ! content-security-policy
+`)
+	file, err := headers.Parse(r)
+	assert.NoError(t, err)
+
+	reportOnly := file.ToReportOnly()
+
+	assert.Equal(t, []headers.Header{
+		{Name: "Content-Security-Policy-Report-Only", Value: "default-src 'self';"},
+		{Name: "X-Frame-Options", Value: "DENY"},
+	}, reportOnly[0].Headers)
+	assert.Equal(t, []headers.Header{
+		{Name: "Content-Security-Policy-Report-Only", Detach: true},
+	}, reportOnly[1].Headers)
+
+	// the original is left untouched
+	assert.Equal(t, "Content-Security-Policy", (*file)[0].Headers[0].Name)
+	assert.Equal(t, "content-security-policy", (*file)[1].Headers[0].Name)
+}
+
+func Test_File_ExpandWWW(t *testing.T) {
+	r := strings.NewReader(`https://example.com/*
+  X-Site: example
+
+https://www.example.dev/*
+  X-Site: dev
+
+https://:subdomain.example.org/*
+  X-Subdomain: :subdomain
+
+https://*.example.net/*
+  X-Splat: :splat
+
+/secure/page
+  X-Frame-Options: DENY
+`)
+	file, err := headers.Parse(r)
+	assert.NoError(t, err)
+
+	expanded := file.ExpandWWW()
+	assert.Len(t, expanded, 7)
+
+	tests := []struct {
+		inputURL string
+		expected []string
+	}{
+		{"https://example.com/", []string{"X-Site: example"}},
+		{"https://www.example.com/", []string{"X-Site: example"}},
+		{"https://example.dev/", []string{"X-Site: dev"}},
+		{"https://www.example.dev/", []string{"X-Site: dev"}},
+		{"https://www.example.org/", []string{"X-Subdomain: www"}},
+		{"https://www.example.net/", []string{"X-Splat: www"}},
+		{"https://example.org/", []string{}},
+		{"https://example.net/", []string{}},
+		{"https://www.example.com/secure/page", []string{"X-Site: example", "X-Frame-Options: DENY"}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.inputURL, func(t *testing.T) {
+			input, err := url.Parse(test.inputURL)
+			assert.NoError(t, err)
+
+			assert.ElementsMatch(t, test.expected, expanded.Match(*input))
+		})
+	}
+}
+
+func Test_File_RenamePlaceholder(t *testing.T) {
+	r := strings.NewReader(`/users/:id
+  X-User: :id
+  X-Other: :idx
+
+https://:id.example.com/*
+  X-Host: :id
+
+/links/*
+  Link: <https://x.com:id>
+`)
+	file, err := headers.Parse(r)
+	assert.NoError(t, err)
+
+	count, err := file.RenamePlaceholder("id", "userId")
+	assert.NoError(t, err)
+	assert.Equal(t, 4, count)
+
+	assert.Equal(t, "/users/:userId", (*file)[0].Pattern.Path)
+	assert.Equal(t, []headers.Header{
+		{Name: "X-User", Value: ":userId"},
+		{Name: "X-Other", Value: ":idx"},
+	}, (*file)[0].Headers)
+	assert.Equal(t, ":userId.example.com", (*file)[1].Pattern.Host)
+	assert.Equal(t, ":userId", (*file)[1].Headers[0].Value)
+	assert.Equal(t, "<https://x.com:id>", (*file)[2].Headers[0].Value)
+
+	input, err := url.Parse("https://example.com/users/42")
+	assert.NoError(t, err)
+	assert.ElementsMatch(t, []string{"X-User: 42", "X-Other: :idx"}, file.Match(*input))
+}
+
+func Test_File_RenamePlaceholder_Invalid(t *testing.T) {
+	r := strings.NewReader(`/users/:id
+  X-User: :id
+`)
+	file, err := headers.Parse(r)
+	assert.NoError(t, err)
+
+	for _, name := range []string{"", "1st", "user-id", ":userId"} {
+		t.Run(name, func(t *testing.T) {
+			count, err := file.RenamePlaceholder("id", name)
+			assert.Error(t, err)
+			assert.Equal(t, 0, count)
+			assert.Equal(t, "/users/:id", (*file)[0].Pattern.Path)
+		})
+	}
+}
+
+func Test_File_WithSecurityDefaults(t *testing.T) {
+	input, err := url.Parse("https://example.com/page")
+	assert.NoError(t, err)
+
+	t.Run("empty", func(t *testing.T) {
+		file := headers.File{}.WithSecurityDefaults()
+		assert.ElementsMatch(t, []string{
+			"X-Content-Type-Options: nosniff",
+			"X-Frame-Options: DENY",
+			"Referrer-Policy: no-referrer",
+		}, file.Match(*input))
+	})
+
+	t.Run("existing values", func(t *testing.T) {
+		r := strings.NewReader(`/page
+  x-frame-options: SAMEORIGIN
+
+/*.jpg
+  ! Referrer-Policy
+`)
+		file, err := headers.Parse(r)
+		assert.NoError(t, err)
+
+		defaults := file.WithSecurityDefaults()
+		assert.Len(t, defaults, 3)
+		assert.Equal(t, "/*", defaults[0].Pattern.Path)
+		assert.ElementsMatch(t, []string{
+			"X-Content-Type-Options: nosniff",
+			"x-frame-options: SAMEORIGIN",
+			"Referrer-Policy: no-referrer",
+		}, defaults.Match(*input))
+
+		image, err := url.Parse("https://example.com/image.jpg")
+		assert.NoError(t, err)
+		assert.ElementsMatch(t, []string{"X-Content-Type-Options: nosniff", "X-Frame-Options: DENY"}, defaults.Match(*image))
+
+		// the original is left untouched
+		assert.Len(t, *file, 2)
+		assert.Len(t, (*file)[0].Headers, 1)
+	})
+
+	t.Run("one path sets a default", func(t *testing.T) {
+		r := strings.NewReader(`/embed
+  X-Frame-Options: SAMEORIGIN
+`)
+		file, err := headers.Parse(r)
+		assert.NoError(t, err)
+
+		defaults := file.WithSecurityDefaults()
+		assert.Equal(t, []headers.Header{
+			{Name: "X-Frame-Options", Value: "DENY", Detach: true},
+			{Name: "X-Frame-Options", Value: "SAMEORIGIN"},
+		}, defaults[1].Headers)
+
+		embed, err := url.Parse("https://example.com/embed")
+		assert.NoError(t, err)
+		assert.ElementsMatch(t, []string{
+			"X-Content-Type-Options: nosniff",
+			"X-Frame-Options: SAMEORIGIN",
+			"Referrer-Policy: no-referrer",
+		}, defaults.Match(*embed))
+
+		// every other path still gets the default
+		assert.ElementsMatch(t, []string{
+			"X-Content-Type-Options: nosniff",
+			"X-Frame-Options: DENY",
+			"Referrer-Policy: no-referrer",
+		}, defaults.Match(*input))
+	})
+
+	t.Run("existing splat rule", func(t *testing.T) {
+		r := strings.NewReader(`/*
+  X-Content-Type-Options: nosniff
+  X-Robots-Tag: noindex
+`)
+		file, err := headers.Parse(r)
+		assert.NoError(t, err)
+
+		defaults := file.WithSecurityDefaults()
+		assert.Len(t, defaults, 1)
+		assert.Equal(t, []headers.Header{
+			{Name: "X-Content-Type-Options", Value: "nosniff"},
+			{Name: "X-Robots-Tag", Value: "noindex"},
+			{Name: "X-Frame-Options", Value: "DENY"},
+			{Name: "Referrer-Policy", Value: "no-referrer"},
+		}, defaults[0].Headers)
+		assert.Len(t, (*file)[0].Headers, 2)
+	})
+}
+
+func Test_File_NormalizeValues(t *testing.T) {
+	r := strings.NewReader("/*\n" +
+		"  X-Content-Type-Options: NoSniff\n" +
+		"  X-Frame-Options: sameorigin\n" +
+		"  Referrer-Policy: Strict-Origin-When-Cross-Origin\n" +
+		"  Content-Security-Policy: default-src 'self';;  img-src   *  ;\n" +
+		"  X-Custom: Some   \t Value\n" +
+		"  ! X-Robots-Tag\n")
+	file, err := headers.Parse(r)
+	assert.NoError(t, err)
+
+	assert.Equal(t, []headers.Header{
+		{Name: "X-Content-Type-Options", Value: "nosniff"},
+		{Name: "X-Frame-Options", Value: "SAMEORIGIN"},
+		{Name: "Referrer-Policy", Value: "strict-origin-when-cross-origin"},
+		{Name: "Content-Security-Policy", Value: "default-src 'self'; img-src *"},
+		{Name: "X-Custom", Value: "Some Value"},
+		{Name: "X-Robots-Tag", Detach: true},
+	}, file.NormalizeValues()[0].Headers)
+
+	// the original is left untouched
+	assert.Equal(t, "NoSniff", (*file)[0].Headers[0].Value)
+
+	// only the X-Frame-Options directive is upper cased, not the origin
+	allowFrom := headers.File{{
+		Pattern: url.URL{Path: "/*"},
+		Headers: []headers.Header{{Name: "X-Frame-Options", Value: "allow-from https://Example.com/Path"}},
+	}}
+	assert.Equal(t, "ALLOW-FROM https://Example.com/Path", allowFrom.NormalizeValues()[0].Headers[0].Value)
+}
+
+func Test_File_Expand(t *testing.T) {
+	r := strings.NewReader(`/movies/:title
+  X-Movie-Name: You are watching ":title"
+  X-Robots-Tag: noindex
+
+/movies/secret
+  ! X-Robots-Tag
+
+/static/*
+  X-Asset: :splat
+`)
+	file, err := headers.Parse(r)
+	assert.NoError(t, err)
+
+	var urls []url.URL
+	for _, raw := range []string{
+		"https://example.com/movies/inception",
+		"https://example.com/movies/secret",
+		"/static/app*.js",
+		"/about",
+	} {
+		u, err := url.Parse(raw)
+		assert.NoError(t, err)
+		urls = append(urls, *u)
+	}
+
+	expanded, err := file.Expand(urls)
+	assert.NoError(t, err)
+	assert.Equal(t, headers.File{
+		{
+			Pattern: url.URL{Scheme: "https", Host: "example.com", Path: "/movies/inception"},
+			Headers: []headers.Header{
+				{Name: "X-Movie-Name", Value: `You are watching "inception"`},
+				{Name: "X-Robots-Tag", Value: "noindex"},
+			},
+		},
+		{
+			Pattern: url.URL{Scheme: "https", Host: "example.com", Path: "/movies/secret"},
+			Headers: []headers.Header{
+				{Name: "X-Movie-Name", Value: `You are watching "secret"`},
+			},
+		},
+		{
+			Pattern: url.URL{Path: `/static/app\*.js`},
+			Headers: []headers.Header{
+				{Name: "X-Asset", Value: "app*.js"},
+			},
+		},
+		{
+			Pattern: url.URL{Path: "/about"},
+			Headers: []headers.Header{},
+		},
+	}, expanded)
+
+	// the expanded file gives the same headers for each URL
+	for _, u := range urls {
+		assert.ElementsMatch(t, file.Match(u), expanded.Match(u))
+	}
+}
+
+func Test_File_Expand_Hosts(t *testing.T) {
+	r := strings.NewReader(`https://a.com/*
+  X-A: a
+
+/x
+  X-X: x
+`)
+	file, err := headers.Parse(r)
+	assert.NoError(t, err)
+
+	var urls []url.URL
+	for _, raw := range []string{
+		"https://a.com/x",
+		"https://b.com/x",
+		"https://a.com/y",
+		"https://b.com/y",
+		"https://a.com/x",
+		"https://a.com/x?page=2",
+	} {
+		u, err := url.Parse(raw)
+		assert.NoError(t, err)
+		urls = append(urls, *u)
+	}
+
+	expanded, err := file.Expand(urls)
+	assert.NoError(t, err)
+	// duplicate URLs are expanded once
+	assert.Len(t, expanded, 4)
+	assert.Equal(t, url.URL{Scheme: "https", Host: "a.com", Path: "/x"}, expanded[0].Pattern)
+
+	for _, u := range urls {
+		assert.Equal(t, file.Match(u), expanded.Match(u), u.String())
+	}
+}
+
+func Test_File_Expand_Errors(t *testing.T) {
+	r := strings.NewReader(`/*
+  X-Path: :splat
+`)
+	file, err := headers.Parse(r)
+	assert.NoError(t, err)
+
+	tests := []struct {
+		name  string
+		urls  []string
+		error string
+	}{
+		{"placeholder", []string{"/:name"}, `cannot expand "/:name": contains a placeholder`},
+		{"with and without host", []string{"/x", "https://a.com/x"}, `cannot expand "/x": path given both with and without a host`},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var urls []url.URL
+			for _, raw := range test.urls {
+				u, err := url.Parse(raw)
+				assert.NoError(t, err)
+				urls = append(urls, *u)
+			}
+			_, err := file.Expand(urls)
+			assert.EqualError(t, err, test.error)
+		})
+	}
+}
+
+func Test_File_Minimize(t *testing.T) {
+	r := strings.NewReader(`/*
+  X-Frame-Options: DENY
+  X-Robots-Tag: noindex
+
+/movies/:title
+  X-Movie-Name: :title
+
+/movies/secret
+  ! X-Robots-Tag
+
+/legacy/*
+  X-Legacy: true
+
+https://old.example.com/*
+  X-Old: true
+`)
+	file, err := headers.Parse(r)
+	assert.NoError(t, err)
+
+	var urls []url.URL
+	for _, raw := range []string{
+		"https://example.com/movies/inception",
+		"https://example.com/movies/secret",
+		"/about",
+	} {
+		u, err := url.Parse(raw)
+		assert.NoError(t, err)
+		urls = append(urls, *u)
+	}
+
+	minimized := file.Minimize(urls)
+	assert.Equal(t, headers.File{(*file)[0], (*file)[1], (*file)[2]}, minimized)
+
+	// the minimized file gives the same headers for each URL
+	for _, u := range urls {
+		assert.Equal(t, file.Match(u), minimized.Match(u))
+	}
+
+	assert.Equal(t, headers.File{}, file.Minimize(nil))
+}
@@ -0,0 +1,101 @@
+package headers
+
+import (
+	"net/url"
+	"sort"
+	"strings"
+)
+
+// PathTrie organizes the exact path rules of a File into a trie keyed by path
+// segment, so they can be found without comparing every rule. Rules with a
+// host, splat or placeholder are still compared one by one.
+type PathTrie struct {
+	file    File
+	root    *trieNode
+	dynamic []int
+}
+
+type trieNode struct {
+	children map[string]*trieNode
+	rules    []int
+}
+
+// BuildTrie builds a PathTrie from the rules of the file.
+func (f File) BuildTrie() *PathTrie {
+	t := &PathTrie{file: f, root: &trieNode{}}
+	for i, rule := range f {
+		if !rule.isStaticPath() {
+			t.dynamic = append(t.dynamic, i)
+			continue
+		}
+		node := t.root
+		for _, segment := range strings.Split(unescapeSplat(rule.Pattern.Path), "/") {
+			if node.children == nil {
+				node.children = make(map[string]*trieNode)
+			}
+			if _, ok := node.children[segment]; !ok {
+				node.children[segment] = &trieNode{}
+			}
+			node = node.children[segment]
+		}
+		node.rules = append(node.rules, i)
+	}
+	return t
+}
+
+// Match all the rules against the input URL, returning the headers to apply.
+// The result is identical to that of File.Match.
+func (t *PathTrie) Match(in url.URL) []string {
+	matches := []RuleMatch{}
+
+	node := t.root
+	for _, segment := range strings.Split(in.Path, "/") {
+		if node = node.children[segment]; node == nil {
+			break
+		}
+	}
+	if node != nil {
+		for _, i := range node.rules {
+			matches = append(matches, RuleMatch{Index: i, Rule: t.file[i]})
+		}
+	}
+
+	for _, i := range t.dynamic {
+		if bindings, ok := t.file[i].match(in, MatchOptions{}); ok {
+			matches = append(matches, RuleMatch{Index: i, Rule: t.file[i], Bindings: bindings})
+		}
+	}
+
+	// headers must be applied in file order
+	sort.Slice(matches, func(a, b int) bool {
+		return matches[a].Index < matches[b].Index
+	})
+
+	return Flatten(matchedHeaders(matches, MatchOptions{}))
+}
+
+// StaticRules returns the rules whose patterns have no splat or placeholder in
+// their host or path, so match only a single literal URL, or path on any host.
+func (f File) StaticRules() File {
+	static := File{}
+	for _, rule := range f {
+		if rule.isStatic() {
+			static = append(static, rule)
+		}
+	}
+	return static
+}
+
+// isStatic reports whether the rule pattern has no splat or placeholder.
+func (r Rule) isStatic() bool {
+	chunks, _ := splitSplat(r.Pattern.Path)
+	return len(chunks) == 1 &&
+		!strings.Contains(r.Pattern.Host, "*") &&
+		!placeholderMatcher.MatchString(r.Pattern.Host+r.Pattern.Path)
+}
+
+// isStaticPath reports whether the rule matches a single literal path, on any
+// host.
+func (r Rule) isStaticPath() bool {
+	return r.Pattern.Host == "" && r.isStatic()
+}
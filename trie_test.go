@@ -0,0 +1,130 @@
+package headers_test
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	headers "github.com/jmhobbs/cloudflare-headers-file"
+)
+
+func Test_PathTrie_Match(t *testing.T) {
+	r := strings.NewReader(`/*
+  X-Robots-Tag: nosnippet
+
+/secure/page
+  X-Frame-Options: DENY
+  X-Robots-Tag: noindex
+
+/secure/page/
+  X-Trailing: true
+
+/secure/:name
+  X-Name: :name
+  ! X-Frame-Options
+
+/a\*b
+  X-Literal: true
+
+https://example.com/*
+  X-Host: true
+
+/secure/page
+  X-Frame-Options: SAMEORIGIN
+`)
+	file, err := headers.Parse(r)
+	assert.NoError(t, err)
+
+	trie := file.BuildTrie()
+
+	for _, raw := range []string{
+		"https://example.com/secure/page",
+		"https://example.dev/secure/page",
+		"https://example.dev/secure/page/",
+		"https://example.dev/secure/other",
+		"https://example.dev/a*b",
+		"https://example.dev/page",
+		"https://example.dev/",
+		"https://example.dev",
+	} {
+		t.Run(raw, func(t *testing.T) {
+			input, err := url.Parse(raw)
+			assert.NoError(t, err)
+
+			assert.ElementsMatch(t, file.Match(*input), trie.Match(*input))
+		})
+	}
+}
+
+func Test_File_StaticRules(t *testing.T) {
+	r := strings.NewReader(`/*
+  X-Robots-Tag: nosnippet
+
+/secure/page
+  X-Frame-Options: DENY
+
+/secure/:name
+  X-Name: :name
+
+/a\*b
+  X-Literal: true
+
+https://example.com/about
+  X-Host: true
+
+https://*.example.com/about
+  X-Host-Splat: true
+
+https://:subdomain.example.com/about
+  X-Subdomain: :subdomain
+`)
+	file, err := headers.Parse(r)
+	assert.NoError(t, err)
+
+	static := file.StaticRules()
+
+	lines := []int{}
+	for _, rule := range static {
+		lines = append(lines, rule.Line)
+	}
+	assert.Equal(t, []int{4, 10, 13}, lines)
+
+	assert.Empty(t, headers.File{}.StaticRules())
+}
+
+func benchmarkFile(b *testing.B) headers.File {
+	var sb strings.Builder
+	for i := 0; i < 500; i++ {
+		fmt.Fprintf(&sb, "/section-%d/page-%d\n  X-Page: %d\n\n", i%20, i, i)
+	}
+	sb.WriteString("/static/*\n  Cache-Control: max-age=3600\n")
+
+	file, err := headers.Parse(strings.NewReader(sb.String()))
+	if err != nil {
+		b.Fatal(err)
+	}
+	return *file
+}
+
+func Benchmark_File_Match(b *testing.B) {
+	file := benchmarkFile(b)
+	input, _ := url.Parse("https://example.com/section-19/page-499")
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		file.Match(*input)
+	}
+}
+
+func Benchmark_PathTrie_Match(b *testing.B) {
+	trie := benchmarkFile(b).BuildTrie()
+	input, _ := url.Parse("https://example.com/section-19/page-499")
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		trie.Match(*input)
+	}
+}